@@ -9,10 +9,19 @@ export CPPFLAGS="-I/usr/local/opt/openssl/include $CPPFLAGS"
 export CMAKE_PREFIX_PATH="/usr/local/lib;/usr/lib;/usr/lib/x86_64-linux-gnu;$CMAKE_PREFIX_PATH"
 export PKG_CONFIG_PATH="/usr/local/opt/openssl/lib/pkgconfig:/usr/local/lib/pkgconfig:/usr/lib/pkgconfig:/usr/lib/x86_64-linux-gnu/pkgconfig:$PKG_CONFIG_PATH"
 
-# Fetch go packages
+# Fetch go packages (go-build requires Go 1.20 or later)
 go get -d github.com/op/go-logging
 go get -d github.com/libgit2/git2go
 go get -d github.com/getsentry/raven-go
+go get -d github.com/klauspost/compress/zstd
+go get -d golang.org/x/crypto/...
+go get -d github.com/minio/minio-go/v7
+
+# The core and plugins import the plugin API from this checkout
+if [ ! -e "$GOPATH/src/github.com/Danw33/go-build" ]; then
+  mkdir -p "$GOPATH/src/github.com/Danw33"
+  ln -s "$pwd" "$GOPATH/src/github.com/Danw33/go-build"
+fi
 
 rm -rf vendor; mkdir vendor ; cd vendor
 vendor="$(pwd)"
@@ -0,0 +1,117 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pluginapi
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ProjectConfig defines the project-level configuration, and is utilised within
+// the Configuration struct of the core
+type ProjectConfig struct {
+	URL       string                  `json:"url"`
+	Path      string                  `json:"path"`
+	Artifacts string                  `json:"artifacts"`
+	Plugins   []PluginConfig          `json:"plugins"`
+	Hooks     map[string][]HookConfig `json:"hooks"`
+	Branches  []string                `json:"branches"`
+	Scripts   []string                `json:"scripts"`
+	Env       []EnvVar                `json:"env"`
+	Archive   ArchiveConfig           `json:"archive"`
+}
+
+// EnvVar defines an environment variable passed to the scripts of a project,
+// taking its value either from the configuration or from the environment of
+// go-build itself. It is utilised within the ProjectConfig struct
+type EnvVar struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	FromEnv string `json:"fromEnv"`
+	Secret  bool   `json:"secret"`
+}
+
+// ArchiveConfig defines how the build artifacts of each branch are packaged
+// into archives, and is utilised within the ProjectConfig struct
+type ArchiveConfig struct {
+	Formats []string `json:"formats"`
+	Name    string   `json:"name"`
+	Only    bool     `json:"only"`
+}
+
+// PluginConfig defines an entry of a plugin list, in the global configuration
+// or that of a project. Each plugin is passed only its own Config section.
+// Plugins are Go plugins unless their Type is "exec", for executables run
+// with Args and spoken to over JSON-RPC, which have Timeout seconds to answer.
+// Dispatch may be "serial" or "concurrent" to override whether the plugin
+// declares itself ConcurrentSafe
+type PluginConfig struct {
+	Path     string          `json:"path"`
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Args     []string        `json:"args"`
+	Timeout  int             `json:"timeout"`
+	Dispatch string          `json:"dispatch"`
+	Disabled bool            `json:"disabled"`
+	Config   json.RawMessage `json:"config"`
+}
+
+// UnmarshalJSON also accepts an entry given as a string: the path of a plugin
+// file, or the path or name of a plugin prefixed with "!" to disable it
+func (p *PluginConfig) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*p = PluginConfig{Path: strings.TrimPrefix(path, "!"), Disabled: strings.HasPrefix(path, "!")}
+		return nil
+	}
+
+	// Decoding into another type stops this method being called recursively
+	type pluginConfig PluginConfig
+	return json.Unmarshal(data, (*pluginConfig)(p))
+}
+
+// HookConfig defines a shell command run at a point of the build, given in
+// the hooks of the global configuration or that of a project. It has Timeout
+// seconds to finish, and OnError is what its failure means: "warn" (the
+// default), "ignore", "skip" the project, branch or artifacts it is run
+// before, "fail" the branch or project, or "abort" the run
+type HookConfig struct {
+	Command string `json:"command"`
+	Timeout int    `json:"timeout"`
+	OnError string `json:"onError"`
+}
+
+// UnmarshalJSON also accepts a hook given as a string, the command alone
+func (h *HookConfig) UnmarshalJSON(data []byte) error {
+	var command string
+	if err := json.Unmarshal(data, &command); err == nil {
+		*h = HookConfig{Command: command}
+		return nil
+	}
+
+	type hookConfig HookConfig
+	return json.Unmarshal(data, (*hookConfig)(h))
+}
@@ -0,0 +1,134 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pluginapi
+
+import (
+	"context"
+	"time"
+)
+
+// RunContext describes the go-build run, and is passed to the run-level hooks
+type RunContext struct {
+	// Context is cancelled when the run is aborted
+	Context context.Context `json:"-"`
+
+	// ID identifies the run, as used in logs, events and traces
+	ID string `json:"id"`
+
+	// Version and BuildTime of the go-build core
+	Version   string `json:"version"`
+	BuildTime string `json:"buildTime"`
+
+	// WorkingDir go-build was started in, and the configured HomeDir
+	WorkingDir string `json:"workingDir"`
+	HomeDir    string `json:"homeDir"`
+
+	// Async is set when projects are built in parallel
+	Async bool `json:"async"`
+}
+
+// ProjectContext describes a project being processed
+type ProjectContext struct {
+	*RunContext
+
+	// Project is the configuration of the project, with its secrets masked.
+	// Masked values a hook leaves as they are keep their real secrets
+	Project *ProjectConfig
+
+	// Dir is the working directory the project is checked out in
+	Dir string
+}
+
+// BranchContext describes a branch of a project being processed
+type BranchContext struct {
+	*ProjectContext
+
+	// Name of the branch, and the Commit checked out for it
+	Name   string
+	Commit string
+
+	// Description of the working directory state after checkout
+	Description string
+
+	// Published is set once the artifacts of the branch have been published
+	Published bool
+}
+
+// ScriptContext describes a script of a branch being built, which is run in
+// the project's Dir
+type ScriptContext struct {
+	*BranchContext
+
+	// Index of the script in the project's scripts
+	Index int
+
+	// Command is the script after template expansion
+	Command string
+
+	// Env is the environment the script is run with in addition to that of
+	// go-build, in "NAME=value" form, with its secrets masked as Project's are
+	Env []string
+
+	// StdoutLog and StderrLog are the paths of the script's output logs
+	StdoutLog string
+	StderrLog string
+
+	// ExitCode of the script, once it has run
+	ExitCode int
+}
+
+// ArtifactsContext describes the build artifacts of a branch
+type ArtifactsContext struct {
+	*BranchContext
+
+	// Path the artifacts are picked up from
+	Path string
+
+	// URL the artifacts are published at, if a public base URL is configured
+	URL string
+}
+
+// Status is the outcome of a project, branch or run
+type Status string
+
+const (
+	// StatusSucceeded is the status of work that completed
+	StatusSucceeded Status = "succeeded"
+
+	// StatusFailed is the status of work that failed, see Result.Err
+	StatusFailed Status = "failed"
+
+	// StatusSkipped is the status of work a hook decided to skip, with
+	// Result.Err giving the reason
+	StatusSkipped Status = "skipped"
+)
+
+// Result is passed to the post-processing hooks
+type Result struct {
+	Status   Status
+	Err      error
+	Duration time.Duration
+}
@@ -0,0 +1,108 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pluginapi
+
+import "errors"
+
+// Severity decides what the core does with an error returned by a hook
+type Severity int
+
+const (
+	// SeverityWarn errors are logged and reported, and the run carries on
+	SeverityWarn Severity = iota
+
+	// SeveritySkip errors are the decision of ConfigureProject or a
+	// pre-processing hook to skip the project, branch or script it was called
+	// for, or publishing the artifacts, with the error as the reason. From any
+	// other hook they are logged, and the run carries on
+	SeveritySkip
+
+	// SeverityFailBranch errors fail the branch the hook was called for, or
+	// the project for project-level hooks, or the run for run-level hooks
+	SeverityFailBranch
+
+	// SeverityAbortRun errors cancel the run: running scripts are killed, and
+	// no further projects or branches are started
+	SeverityAbortRun
+)
+
+// String returns the name of the severity
+func (s Severity) String() string {
+	switch s {
+	case SeveritySkip:
+		return "skip"
+	case SeverityFailBranch:
+		return "fail"
+	case SeverityAbortRun:
+		return "abort"
+	}
+	return "warn"
+}
+
+// Error is an error returned by a hook with an explicit severity
+type Error struct {
+	Severity Severity
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Warn marks an error as a warning
+func Warn(err error) error {
+	return &Error{SeverityWarn, err}
+}
+
+// Skip returns the decision to skip the current project, branch, script or
+// artifacts, for the given reason
+func Skip(reason string) error {
+	return &Error{SeveritySkip, errors.New(reason)}
+}
+
+// FailBranch marks an error as failing the current branch
+func FailBranch(err error) error {
+	return &Error{SeverityFailBranch, err}
+}
+
+// AbortRun marks an error as aborting the whole run
+func AbortRun(err error) error {
+	return &Error{SeverityAbortRun, err}
+}
+
+// SeverityOf returns the severity of an error returned by a hook. Errors not
+// created by Warn, Skip, FailBranch or AbortRun are warnings
+func SeverityOf(err error) Severity {
+	if e, ok := err.(*Error); ok {
+		return e.Severity
+	}
+	return SeverityWarn
+}
@@ -0,0 +1,155 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package pluginapi defines version 2 of the go-build plugin interface.
+//
+// A plugin exports a BuildPlugin symbol implementing Plugin, plus any of the
+// hook interfaces it needs. Hooks receive a typed context for the point of the
+// run they are called at, and return an error whose severity (see Warn,
+// FailBranch and AbortRun) decides what the core does about it. Pre-processing
+// hooks decide whether the work they are called for goes ahead: nil continues,
+// Skip skips it, and FailBranch fails it, each with a reason.
+package pluginapi
+
+import "context"
+
+// Version is the version of the plugin interface defined by this package
+const Version = 2
+
+// Plugin is the interface every version 2 plugin implements. Hooks are
+// implemented optionally, by also implementing their single-method interface
+type Plugin interface {
+	// PluginInit is called on load of the plugin, and receives the raw config
+	// section of its entry in the global plugin list (to be parsed with
+	// json.Unmarshal), which is empty if there is none
+	PluginInit(ctx context.Context, config []byte) error
+}
+
+// ConcurrentSafe is implemented by plugins whose hooks can be called from many
+// goroutines at once, as they are for different projects in async runs, when
+// ConcurrentSafe returns true. The hooks of other plugins are serialized, so
+// that only one runs at a time for each plugin. Either way, the run-level hooks
+// are never called alongside others: PreProcessProjects returns before the
+// first project starts, and PostProcessProjects is called once all are done,
+// so state set by the first can be read by project hooks without a lock
+type ConcurrentSafe interface {
+	ConcurrentSafe() bool
+}
+
+// ConfigSchema is implemented by plugins that describe their config section
+// with a JSON Schema, which `go-build validate` checks the global and project
+// sections against. Validation doesn't initialise plugins, so ConfigSchema is
+// called without PluginInit and must not depend on it
+type ConfigSchema interface {
+	ConfigSchema() []byte
+}
+
+// TemplateVariables is implemented by plugins that add variables to the
+// templated fields of a project's configuration: its scripts, artifacts path
+// and archive name. TemplateVariables names them, and is called once the
+// plugin is initialised, so that a name already used by go-build or another
+// plugin stops it from loading. TemplateValues gives their values for each
+// branch once it is checked out, and its error is handled as a hook's
+type TemplateVariables interface {
+	TemplateVariables() []string
+	TemplateValues(branch *BranchContext) (map[string]interface{}, error)
+}
+
+// TemplateFuncs is implemented by plugins that add functions to the templated
+// fields of a project's configuration, as text/template's Funcs does. It is
+// called once the plugin is initialised, so that a name already used by
+// text/template or another plugin stops it from loading. The functions may be
+// called for many branches at once
+type TemplateFuncs interface {
+	TemplateFuncs() map[string]interface{}
+}
+
+// PostLoadPluginsHook 1. First hook, after plugins are loaded
+type PostLoadPluginsHook interface {
+	PostLoadPlugins(run *RunContext) error
+}
+
+// PreProcessProjectsHook 2. Before processing all projects
+type PreProcessProjectsHook interface {
+	PreProcessProjects(run *RunContext) error
+}
+
+// ConfigureProjectHook 3. Before processing an individual project the plugin
+// is active for, receives the raw config section of its entry in the plugin
+// list of the project, which is empty if there is none
+type ConfigureProjectHook interface {
+	ConfigureProject(project *ProjectContext, config []byte) error
+}
+
+// PreProcessProjectHook 3. Before processing an individual project, the
+// project configuration may be changed here
+type PreProcessProjectHook interface {
+	PreProcessProject(project *ProjectContext) error
+}
+
+// PreProcessBranchHook 4. Before processing a branch within a project
+type PreProcessBranchHook interface {
+	PreProcessBranch(branch *BranchContext) error
+}
+
+// PreScriptHook 4a. Before running each script of a branch, the command and
+// its environment may be changed here
+type PreScriptHook interface {
+	PreScript(script *ScriptContext) error
+}
+
+// PostScriptHook 4b. After running each script of a branch, whether it
+// succeeded or not
+type PostScriptHook interface {
+	PostScript(script *ScriptContext, result *Result) error
+}
+
+// PreProcessArtifactsHook 5. Before processing the build artifacts of a
+// branch, the artifact path may be changed here
+type PreProcessArtifactsHook interface {
+	PreProcessArtifacts(artifacts *ArtifactsContext) error
+}
+
+// PostProcessArtifactsHook 6. After processing the build artifacts of a branch
+type PostProcessArtifactsHook interface {
+	PostProcessArtifacts(artifacts *ArtifactsContext, result *Result) error
+}
+
+// PostProcessBranchHook 7. After processing a branch within a project,
+// whether it succeeded or not
+type PostProcessBranchHook interface {
+	PostProcessBranch(branch *BranchContext, result *Result) error
+}
+
+// PostProcessProjectHook 8. After processing an individual project, whether
+// it succeeded or not
+type PostProcessProjectHook interface {
+	PostProcessProject(project *ProjectContext, result *Result) error
+}
+
+// PostProcessProjectsHook 9. After processing all projects
+type PostProcessProjectsHook interface {
+	PostProcessProjects(run *RunContext, result *Result) error
+}
@@ -0,0 +1,79 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pluginapi
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]interface{}{}
+)
+
+// Register compiles a plugin into the go-build binary under a name, by which
+// it is enabled in the plugin list of the configuration in place of the path
+// of a plugin file. This is how plugins are used in static builds, which can't
+// load plugin files. The plugin is the value a plugin file would export as its
+// BuildPlugin symbol, implementing Plugin or the original plugin interface.
+//
+// Register is meant to be called from the init function of the plugin's
+// package, which is then imported by a file of the core behind a build tag. It
+// panics if the name is empty or already registered
+func Register(name string, plugin interface{}) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if name == "" || plugin == nil {
+		panic("pluginapi: Register needs a name and a plugin")
+	}
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("pluginapi: plugin \"%s\" is already registered", name))
+	}
+	registry[name] = plugin
+}
+
+// Registered returns the plugin compiled in under a name, if any
+func Registered(name string) (interface{}, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	plugin, ok := registry[name]
+	return plugin, ok
+}
+
+// RegisteredNames returns the names of the plugins compiled in, sorted
+func RegisteredNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
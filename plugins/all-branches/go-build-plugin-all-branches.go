@@ -1,14 +1,18 @@
 // go-build-plugin-all-branches
-package main
+package allbranches
 
 import (
 	"fmt"
-	"strings"
 	"os/exec"
+	"strings"
+
+	"github.com/Danw33/go-build/pluginapi"
 )
 
 type BuildPluginImpl struct{}
 
+// abWorkDir is the home directory, set by PreProcessProjects before any
+// project is processed and only read after that
 var abWorkDir string
 
 // pluginInit (0) is the Plugin Initialiser, called on load of plugin file
@@ -17,6 +21,14 @@ func (b BuildPluginImpl) PluginInit(rawConfig []byte) error {
 	return nil
 }
 
+// concurrentSafe is true as PreProcessProject, the only hook with work to do,
+// keeps its state in locals and runs git in its own project's directory. The
+// abWorkDir it reads is only set by PreProcessProjects, which returns before
+// any project starts
+func (b BuildPluginImpl) ConcurrentSafe() bool {
+	return true
+}
+
 // postLoadPlugins (1) is the first fully-loaded hook, after all plugins are loaded
 func (b BuildPluginImpl) PostLoadPlugins(version *string, buildTime *string) {
 	fmt.Println("All-Branches Plugin running against core version", *version)
@@ -94,3 +106,8 @@ func (b BuildPluginImpl) PreProcessArtifacts(artifactPath *string, projectPath *
 func (b BuildPluginImpl) PostProcessArtifacts(artifactPath *string, projectPath *string, branchName *string) { }
 
 var BuildPlugin BuildPluginImpl
+
+// init registers the plugin, so that it can be compiled into go-build
+func init() {
+	pluginapi.Register("all-branches", BuildPlugin)
+}
@@ -0,0 +1,7 @@
+// go-build-plugin-all-branches - The all-branches plugin, built as a plugin file
+package main
+
+import allbranches "github.com/Danw33/go-build/plugins/all-branches"
+
+// BuildPlugin is the plugin symbol looked up by go-build
+var BuildPlugin = allbranches.BuildPlugin
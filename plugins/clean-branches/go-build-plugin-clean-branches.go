@@ -1,11 +1,13 @@
 // go-build-plugin-clean-branches
-package main
+package cleanbranches
 
 import (
-	"fmt"
 	"bytes"
-	"strings"
+	"fmt"
 	"os/exec"
+	"strings"
+
+	"github.com/Danw33/go-build/pluginapi"
 )
 
 type BuildPluginImpl struct{}
@@ -16,6 +18,12 @@ func (b BuildPluginImpl) PluginInit(rawConfig []byte) error {
 	return nil
 }
 
+// concurrentSafe is true as the plugin keeps no state: PreProcessBranch only
+// runs git clean in the directory of the project it is called for
+func (b BuildPluginImpl) ConcurrentSafe() bool {
+	return true
+}
+
 // postLoadPlugins (1) is the first fully-loaded hook, after all plugins are loaded
 func (b BuildPluginImpl) PostLoadPlugins(version *string, buildTime *string) {}
 
@@ -69,3 +77,8 @@ func (b BuildPluginImpl) PreProcessArtifacts(artifactPath *string, projectPath *
 func (b BuildPluginImpl) PostProcessArtifacts(artifactPath *string, projectPath *string, branchName *string) {}
 
 var BuildPlugin BuildPluginImpl
+
+// init registers the plugin, so that it can be compiled into go-build
+func init() {
+	pluginapi.Register("clean-branches", BuildPlugin)
+}
@@ -0,0 +1,7 @@
+// go-build-plugin-clean-branches - The clean-branches plugin, built as a plugin file
+package main
+
+import cleanbranches "github.com/Danw33/go-build/plugins/clean-branches"
+
+// BuildPlugin is the plugin symbol looked up by go-build
+var BuildPlugin = cleanbranches.BuildPlugin
@@ -0,0 +1,83 @@
+#!/usr/bin/env python3
+# go-build-plugin-example-exec
+#
+# An executable plugin: go-build writes one JSON-RPC request per line to its
+# standard input, and it answers each on its standard output. Anything written
+# to standard error is logged by go-build. PluginInit comes first, except for
+# go-build validate, which only asks for ConfigSchema without initialising the
+# plugin, so the schema is fixed rather than built from the config.
+
+import json
+import sys
+
+greeting = "Yo, EX-to-the-A to-the-M to-the-PLE."
+
+config_schema = {
+    "type": "object",
+    "properties": {
+        "greeting": {"type": "string", "minLength": 1}
+    },
+    "additionalProperties": False
+}
+
+
+def log(message):
+    print(message, file=sys.stderr, flush=True)
+
+
+def plugin_init(params):
+    global greeting
+    greeting = params.get("config", {}).get("greeting", greeting)
+    log("Example Exec Plugin: PluginInit; API version %d" % params["apiVersion"])
+    return {"hooks": ["PreProcessProject", "PreProcessBranch", "PostProcessBranch"], "variables": ["ExampleExecGreeting"]}
+
+
+def pre_process_project(params):
+    log("Example Exec Plugin: %s Processing %s" % (greeting, params["project"]["path"]))
+    return None
+
+
+def pre_process_branch(params):
+    branch = params["branch"]
+    log("Example Exec Plugin: Building %s at %s" % (branch["name"], branch["commit"]))
+    return None
+
+
+def template_values(params):
+    return {"ExampleExecGreeting": greeting}
+
+
+def post_process_branch(params):
+    result = params["result"]
+    log("Example Exec Plugin: Branch %s %s in %.1fs" % (params["branch"]["name"], result["status"], result["duration"]))
+    return None
+
+
+methods = {
+    "PluginInit": plugin_init,
+    "ConfigSchema": lambda params: config_schema,
+    "PreProcessProject": pre_process_project,
+    "PreProcessBranch": pre_process_branch,
+    "PostProcessBranch": post_process_branch,
+    "TemplateValues": template_values,
+}
+
+
+for line in sys.stdin:
+    request = json.loads(line)
+    if request["method"] == "Shutdown":
+        break
+    if "id" not in request:
+        continue
+
+    response = {"jsonrpc": "2.0", "id": request["id"]}
+    method = methods.get(request["method"])
+    if method is None:
+        response["error"] = {"code": -32601, "message": "method not found"}
+    else:
+        try:
+            response["result"] = method(request.get("params"))
+        except Exception as e:
+            response["error"] = {"code": -32000, "message": str(e), "data": {"severity": "warn"}}
+
+    print(json.dumps(response), flush=True)
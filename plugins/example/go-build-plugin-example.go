@@ -1,64 +1,149 @@
 // go-build-plugin-example
-package main
+package example
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
+
+	"github.com/Danw33/go-build/pluginapi"
 )
 
 type BuildPluginImpl struct{}
 
+// exampleConfig is the config section of the example plugin
+type exampleConfig struct {
+	Greeting string `json:"greeting"`
+}
+
+var config = exampleConfig{Greeting: "Yo, EX-to-the-A to-the-M to-the-PLE."}
+
+// configSchema describes the config section of the example plugin, for `go-build validate`
+const configSchema = `{
+	"type": "object",
+	"properties": {
+		"greeting": {"type": "string", "minLength": 1}
+	},
+	"additionalProperties": false
+}`
+
 // pluginInit (0) is the Plugin Initialiser, called on load of plugin file
-func (b BuildPluginImpl) PluginInit(rawConfig []byte) error {
-	fmt.Println("Yo, EX-to-the-A to-the-M to-the-PLE.")
+func (b BuildPluginImpl) PluginInit(ctx context.Context, rawConfig []byte) error {
+	if len(rawConfig) > 0 {
+		if err := json.Unmarshal(rawConfig, &config); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(config.Greeting)
 	fmt.Println("Yeah that's right; I'm the example plugin.")
-	fmt.Println("I've been given the following byte array to represent the raw configuration:")
-	fmt.Println(rawConfig)
+	fmt.Println("I've been given the following config section:", string(rawConfig))
 	return nil
 }
 
+// concurrentSafe is true as the hooks only print what they are given. The
+// config is only written by PluginInit, before any hook is called
+func (b BuildPluginImpl) ConcurrentSafe() bool {
+	return true
+}
+
+// configSchema returns the JSON Schema of the plugin's config section
+func (b BuildPluginImpl) ConfigSchema() []byte {
+	return []byte(configSchema)
+}
+
+// templateVariables names the variables the plugin adds to project templates
+func (b BuildPluginImpl) TemplateVariables() []string {
+	return []string{"ExampleGreeting"}
+}
+
+// templateValues gives the values of the plugin's template variables for a branch
+func (b BuildPluginImpl) TemplateValues(branch *pluginapi.BranchContext) (map[string]interface{}, error) {
+	fmt.Println("Example Plugin: TemplateValues - Giving my template variables for branch", branch.Name, "of", branch.Project.Path)
+	return map[string]interface{}{"ExampleGreeting": config.Greeting}, nil
+}
+
+// templateFuncs returns the functions the plugin adds to project templates
+func (b BuildPluginImpl) TemplateFuncs() map[string]interface{} {
+	return map[string]interface{}{"exampleShout": strings.ToUpper}
+}
+
 // postLoadPlugins (1) is the first fully-loaded hook, after all plugins are loaded
-func (b BuildPluginImpl) PostLoadPlugins(version *string, buildTime *string) {
-	fmt.Println("Example Plugin: PostLoadPlugins - All plugins have loaded, we know the core was built at", *buildTime, "and is version", *version)
+func (b BuildPluginImpl) PostLoadPlugins(run *pluginapi.RunContext) error {
+	fmt.Println("Example Plugin: PostLoadPlugins - All plugins have loaded, we know the core was built at", run.BuildTime, "and is version", run.Version)
+	return nil
 }
 
 // preProcessProjects (2) is run before processing all projects
-func (b BuildPluginImpl) PreProcessProjects(workingDir *string, homeDir *string, async *bool) {
-	fmt.Println("Example Plugin: PreProcessProjects - Just about to start processing the projects, I know that we're in", *workingDir, " and the configuration wants us in", *homeDir)
+func (b BuildPluginImpl) PreProcessProjects(run *pluginapi.RunContext) error {
+	fmt.Println("Example Plugin: PreProcessProjects - Just about to start processing the projects, I know that we're in", run.WorkingDir, " and the configuration wants us in", run.HomeDir)
+	return nil
 }
 
 // postProcessProjects (9) is run after processing all projects
-func (b BuildPluginImpl) PostProcessProjects(workingDir *string, homeDir *string, async *bool) {
-	fmt.Println("Example Plugin: PreProcessProjects - Just finished processing the projects")
+func (b BuildPluginImpl) PostProcessProjects(run *pluginapi.RunContext, result *pluginapi.Result) error {
+	fmt.Println("Example Plugin: PostProcessProjects - Just finished processing the projects, the run", result.Status, "after", result.Duration)
+	return nil
+}
+
+// configureProject (3) is run before processing an individual project, with the plugin's section of its configuration
+func (b BuildPluginImpl) ConfigureProject(project *pluginapi.ProjectContext, rawConfig []byte) error {
+	fmt.Println("Example Plugin: ConfigureProject - The project known as", project.Project.Path, "gave me the following config section:", string(rawConfig))
+	return nil
 }
 
 // preProcessProject (3) is run before processing an individual project
-func (b BuildPluginImpl) PreProcessProject(url *string, path *string, artifacts *string, branches *[]string, scripts *[]string) {
-	fmt.Println("Example Plugin: PreProcessProjects - Just about to start processing an individual project known as", *path)
+func (b BuildPluginImpl) PreProcessProject(project *pluginapi.ProjectContext) error {
+	fmt.Println("Example Plugin: PreProcessProject - Just about to start processing an individual project known as", project.Project.Path)
+	return nil
 }
 
 // postProcessProject (8) is run after processing an individual project
-func (b BuildPluginImpl) PostProcessProject(url *string, path *string, artifacts *string, branches *[]string, scripts *[]string) {
-	fmt.Println("Example Plugin: PostProcessProject - Just finished processing an individual project known as", *path)
+func (b BuildPluginImpl) PostProcessProject(project *pluginapi.ProjectContext, result *pluginapi.Result) error {
+	fmt.Println("Example Plugin: PostProcessProject - Just finished processing an individual project known as", project.Project.Path, "which", result.Status)
+	return nil
 }
 
 // preProcessBranch (4) is run before processing a branch within a project
-func (b BuildPluginImpl) PreProcessBranch(projectDir *string, branchName *string, workDirDesc *string) {
-	fmt.Println("Example Plugin: PreProcessBranch - Just about to start processing a branch of an individual project, the branch is", *branchName, "and the working directory is described as", *workDirDesc)
+func (b BuildPluginImpl) PreProcessBranch(branch *pluginapi.BranchContext) error {
+	fmt.Println("Example Plugin: PreProcessBranch - Just about to start processing a branch of an individual project, the branch is", branch.Name, "at commit", branch.Commit, "and the working directory is described as", branch.Description)
+	return nil
 }
 
 // postProcessBranch (7) is run after processing a branch within a project
-func (b BuildPluginImpl) PostProcessBranch(projectDir *string, branchName *string, workDirDesc *string) {
-	fmt.Println("Example Plugin: PostProcessBranch - Just finished processing a branch of an individual project, the branch was", *branchName, "and the working directory is now described as", *workDirDesc)
+func (b BuildPluginImpl) PostProcessBranch(branch *pluginapi.BranchContext, result *pluginapi.Result) error {
+	fmt.Println("Example Plugin: PostProcessBranch - Just finished processing a branch of an individual project, the branch was", branch.Name, "and it", result.Status)
+	return nil
+}
+
+// preScript (4a) is run before running each script of a branch
+func (b BuildPluginImpl) PreScript(script *pluginapi.ScriptContext) error {
+	fmt.Println("Example Plugin: PreScript - Just about to run script", script.Index, "of branch", script.Name, "which will run", script.Command)
+	return nil
+}
+
+// postScript (4b) is run after running each script of a branch
+func (b BuildPluginImpl) PostScript(script *pluginapi.ScriptContext, result *pluginapi.Result) error {
+	fmt.Println("Example Plugin: PostScript - Just finished running script", script.Index, "of branch", script.Name, "which exited with", script.ExitCode, "after", result.Duration, "and logged to", script.StdoutLog)
+	return nil
 }
 
 // preProcessArtifacts (5) is run before processing the build artifacts of a branch
-func (b BuildPluginImpl) PreProcessArtifacts(artifactPath *string, projectPath *string, branchName *string) {
-	fmt.Println("Example Plugin: PreProcessArtifacts - Just about to start processing artifacts for a branch of an individual project, the project is", *projectPath, ", branch", *branchName, "and the artifacts will be in", *artifactPath)
+func (b BuildPluginImpl) PreProcessArtifacts(artifacts *pluginapi.ArtifactsContext) error {
+	fmt.Println("Example Plugin: PreProcessArtifacts - Just about to start processing artifacts for a branch of an individual project, the project is", artifacts.Project.Path, ", branch", artifacts.Name, "and the artifacts will be in", artifacts.Path)
+	return nil
 }
 
 // postProcessArtifacts (6) is run after processing the build artifacts of a branch
-func (b BuildPluginImpl) PostProcessArtifacts(artifactPath *string, projectPath *string, branchName *string) {
-	fmt.Println("Example Plugin: PreProcessArtifacts - Just finished processing artifacts for a branch of an individual project, the project was", *projectPath, ", branch", *branchName, "and the artifacts will be in", *artifactPath)
+func (b BuildPluginImpl) PostProcessArtifacts(artifacts *pluginapi.ArtifactsContext, result *pluginapi.Result) error {
+	fmt.Println("Example Plugin: PostProcessArtifacts - Just finished processing artifacts for a branch of an individual project, the project was", artifacts.Project.Path, ", branch", artifacts.Name, "and the artifacts were in", artifacts.Path)
+	return nil
 }
 
 var BuildPlugin BuildPluginImpl
+
+// init registers the plugin, so that it can be compiled into go-build
+func init() {
+	pluginapi.Register("example", BuildPlugin)
+}
@@ -0,0 +1,7 @@
+// go-build-plugin-example - The example plugin, built as a plugin file
+package main
+
+import "github.com/Danw33/go-build/plugins/example"
+
+// BuildPlugin is the plugin symbol looked up by go-build
+var BuildPlugin = example.BuildPlugin
@@ -1,18 +1,22 @@
 // go-build-plugin-example
-package main
+package indexgenerator
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
-	"io"
+	"os/exec"
 	"strconv"
 	"strings"
-	"time"
-	"os/exec"
-	"bytes"
+	"sync"
 	"text/template"
-	"io/ioutil"
+	"time"
+
+	"github.com/Danw33/go-build/pluginapi"
 )
 
 type BuildPluginImpl struct{}
@@ -28,6 +32,18 @@ type templateVariables struct {
 	BadgeArtifacts string
 	BadgeProjects  string
 	BadgeBranches  string
+	PublicURL      string
+}
+
+// storageConfig is the part of the core configuration describing where artifacts are published
+type storageConfig struct {
+	Storage struct {
+		Type      string `json:"type"`
+		PublicURL string `json:"publicURL"`
+		Local     struct {
+			Path string `json:"path"`
+		} `json:"local"`
+	} `json:"storage"`
 }
 
 var indexTemplate string
@@ -44,16 +60,32 @@ var badgeProjectsMarkup string
 var badgeBranchesMarkup string
 var projectNames []string
 var branchNames map[string][]string
+var storage storageConfig
+
+// counterLock guards the counters and names, which are added to by the hooks of projects built in parallel
+var counterLock sync.Mutex
 
 // pluginInit (0) is the Plugin Initialiser, called on load of plugin file
 func (b BuildPluginImpl) PluginInit(rawConfig []byte) error {
 	counterProjects = 0
 	counterBranches = 0
 	branchNames = make(map[string][]string)
+	err := json.Unmarshal(rawConfig, &storage)
+	if err != nil {
+		return err
+	}
 	fmt.Println("Indexes Plugin: Index Generator Plugin Initialised.")
 	return nil
 }
 
+// concurrentSafe is true as the project and branch hooks only add to the
+// counters and names, under counterLock. The directories and core version are
+// set by the run-level hooks before any project starts, and the indices are
+// generated by PostProcessProjects once every project is done
+func (b BuildPluginImpl) ConcurrentSafe() bool {
+	return true
+}
+
 // postLoadPlugins (1) is the first fully-loaded hook, after all plugins are loaded
 func (b BuildPluginImpl) PostLoadPlugins(version *string, buildTime *string) {
 	coreVersion = *version
@@ -65,13 +97,26 @@ func (b BuildPluginImpl) PostLoadPlugins(version *string, buildTime *string) {
 func (b BuildPluginImpl) PreProcessProjects(workingDir *string, homeDir *string, async *bool) {
 	homeDirectory = *homeDir
 
-	// This is currently a hard-coded subdirectory - See the core's processArtifacts method in project.go
-	artifactsDirectory = homeDirectory + "/artifacts/"
+	// Artifacts are published under the local storage path, which defaults to the home directory
+	storageRoot := storage.Storage.Local.Path
+	if storageRoot == "" {
+		storageRoot = homeDirectory
+	}
+	artifactsDirectory = storageRoot + "/artifacts/"
 }
 
 // postProcessProjects (9) is run after processing all projects
 func (b BuildPluginImpl) PostProcessProjects(workingDir *string, homeDir *string, async *bool) {
 
+	// Indexes are written to the filesystem, so only local storage is supported
+	if storage.Storage.Type != "" && storage.Storage.Type != "local" {
+		fmt.Println("Indexes Plugin: Artifacts are published to", storage.Storage.Type, "storage, index generation requires local storage and will be skipped.")
+		return
+	}
+
+	counterLock.Lock()
+	defer counterLock.Unlock()
+
 	// Create the badge images
 	processBadges()
 
@@ -91,6 +136,8 @@ func (b BuildPluginImpl) PreProcessProject(url *string, path *string, artifacts
 
 // postProcessProject (8) is run after processing an individual project
 func (b BuildPluginImpl) PostProcessProject(url *string, path *string, artifacts *string, branches *[]string, scripts *[]string) {
+	counterLock.Lock()
+	defer counterLock.Unlock()
 	projectNames = append(projectNames, *path)
 	branchNames[*path] = *branches
 	counterProjects++
@@ -101,7 +148,9 @@ func (b BuildPluginImpl) PreProcessBranch(projectDir *string, branchName *string
 
 // postProcessBranch (7) is run after processing a branch within a project
 func (b BuildPluginImpl) PostProcessBranch(projectDir *string, branchName *string, workDirDesc *string) {
+	counterLock.Lock()
 	counterBranches++
+	counterLock.Unlock()
 }
 
 // preProcessArtifacts (5) is run before processing the build artifacts of a branch
@@ -114,6 +163,11 @@ func (b BuildPluginImpl) PostProcessArtifacts(artifactPath *string, projectPath
 
 var BuildPlugin BuildPluginImpl
 
+// init registers the plugin, so that it can be compiled into go-build
+func init() {
+	pluginapi.Register("index-generator", BuildPlugin)
+}
+
 func loadBaseTemplate ( filePath string ) {
 	templateByte, err := ioutil.ReadFile(filePath)
 	if err != nil {
@@ -296,7 +350,7 @@ func generateBranchLinks( branches []string ) string {
 
 func processIndexTemplate( baseTemplate string, title string, breadcrumb string, links string ) string {
 
-	templateSubstitutions := templateVariables{ title, coreVersion, coreBuildTime, badgeDate, breadcrumb, links, badgeVersionMarkup, badgeArtifactsMarkup, badgeProjectsMarkup, badgeBranchesMarkup }
+	templateSubstitutions := templateVariables{ title, coreVersion, coreBuildTime, badgeDate, breadcrumb, links, badgeVersionMarkup, badgeArtifactsMarkup, badgeProjectsMarkup, badgeBranchesMarkup, strings.TrimSuffix(storage.Storage.PublicURL, "/") }
 
 	t, err := template.New("index").Parse(baseTemplate)
 
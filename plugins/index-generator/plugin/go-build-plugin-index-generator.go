@@ -0,0 +1,7 @@
+// go-build-plugin-index-generator - The index-generator plugin, built as a plugin file
+package main
+
+import indexgenerator "github.com/Danw33/go-build/plugins/index-generator"
+
+// BuildPlugin is the plugin symbol looked up by go-build
+var BuildPlugin = indexgenerator.BuildPlugin
@@ -0,0 +1,324 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// archive - Reproducible archive packaging of build artifacts
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultArchiveName is the archive name template used when a project does
+// not configure one
+const defaultArchiveName = "{{.Project}}-{{.Branch}}-{{.ShortSHA}}"
+
+// archiveEpoch is the timestamp given to every archive entry, so that the same
+// tree always produces byte-identical archives (1980 is the earliest zip date)
+var archiveEpoch = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// archiveFormats maps each supported format to its file extension
+var archiveFormats = map[string]string{
+	"tar.gz":  ".tar.gz",
+	"tar.zst": ".tar.zst",
+	"zip":     ".zip",
+}
+
+type archiveVariables struct {
+	Project  string
+	Branch   string
+	SHA      string
+	ShortSHA string
+}
+
+// archiveEntry is a single file, directory or symlink to be packed, with its
+// path relative to the artifacts root
+type archiveEntry struct {
+	name string
+	path string
+	info os.FileInfo
+	link string
+}
+
+// archiveName expands the configured name template for a branch build, with
+// any trailing archive extension removed so that one can be added per format.
+// The template variables added by plugins are given by tmplValues
+func archiveName(nameTmpl string, project string, branchName string, commitID string, tmplValues map[string]interface{}) (string, error) {
+	if nameTmpl == "" {
+		nameTmpl = defaultArchiveName
+	}
+
+	shortSHA := commitID
+	if len(shortSHA) > 7 {
+		shortSHA = shortSHA[:7]
+	}
+
+	// Branch and project names may contain slashes, which can't appear in a file name
+	vars := archiveVariables{
+		Project:  strings.Replace(project, "/", "-", -1),
+		Branch:   strings.Replace(branchName, "/", "-", -1),
+		SHA:      commitID,
+		ShortSHA: shortSHA,
+	}
+
+	nameStr, err := expandTemplate("archive", nameTmpl, templateData(tmplValues, vars))
+	if err != nil {
+		return "", err
+	}
+
+	for _, ext := range archiveFormats {
+		nameStr = strings.TrimSuffix(nameStr, ext)
+	}
+
+	if nameStr == "" || strings.Contains(nameStr, "/") {
+		return "", errors.New("archive name template must produce a plain, non-empty file name")
+	}
+
+	return nameStr, nil
+}
+
+// createArchives packs the contents of srcDir into each of the given formats,
+// writing them into destDir as name + extension. The paths of the written
+// archives are returned.
+func createArchives(srcDir string, destDir string, name string, formats []string) ([]string, error) {
+	entries, err := collectArchiveEntries(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, err
+	}
+
+	var written []string
+	for _, format := range formats {
+		ext, ok := archiveFormats[format]
+		if !ok {
+			return written, errors.New("unsupported archive format \"" + format + "\"")
+		}
+
+		archivePath := destDir + "/" + name + ext
+		if err := writeArchive(archivePath, format, entries); err != nil {
+			return written, err
+		}
+		written = append(written, archivePath)
+	}
+
+	return written, nil
+}
+
+// collectArchiveEntries walks srcDir in lexical order, returning every entry
+// beneath it
+func collectArchiveEntries(srcDir string) ([]archiveEntry, error) {
+	var entries []archiveEntry
+
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == srcDir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+
+		entry := archiveEntry{name: filepath.ToSlash(rel), path: path, info: info}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if entry.link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		entries = append(entries, entry)
+		return nil
+	})
+
+	return entries, err
+}
+
+// archiveMode normalises permissions so that the umask and ownership of the
+// build host don't leak into the archive
+func archiveMode(info os.FileInfo) os.FileMode {
+	switch {
+	case info.IsDir():
+		return os.ModeDir | 0755
+	case info.Mode()&os.ModeSymlink != 0:
+		return os.ModeSymlink | 0777
+	case info.Mode()&0111 != 0:
+		return 0755
+	default:
+		return 0644
+	}
+}
+
+func writeArchive(archivePath string, format string, entries []archiveEntry) error {
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "tar.gz":
+		err = writeTarGz(file, entries)
+	case "tar.zst":
+		err = writeTarZst(file, entries)
+	case "zip":
+		err = writeZip(file, entries)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func writeTarGz(w io.Writer, entries []archiveEntry) error {
+	// A zero gzip header carries no name or modification time
+	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+
+	if err := writeTar(gz, entries); err != nil {
+		gz.Close()
+		return err
+	}
+
+	return gz.Close()
+}
+
+func writeTarZst(w io.Writer, entries []archiveEntry) error {
+	// A single encoder goroutine keeps the compressed output deterministic
+	zw, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+
+	if err := writeTar(zw, entries); err != nil {
+		zw.Close()
+		return err
+	}
+
+	return zw.Close()
+}
+
+func writeTar(w io.Writer, entries []archiveEntry) error {
+	tw := tar.NewWriter(w)
+
+	for _, entry := range entries {
+		mode := archiveMode(entry.info)
+
+		hdr := &tar.Header{
+			Name:    entry.name,
+			Mode:    int64(mode.Perm()),
+			ModTime: archiveEpoch,
+		}
+
+		switch {
+		case entry.info.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case entry.link != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = entry.link
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = entry.info.Size()
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if hdr.Typeflag == tar.TypeReg {
+			if err := copyFileTo(tw, entry.path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+func writeZip(w io.Writer, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+
+	for _, entry := range entries {
+		hdr := &zip.FileHeader{
+			Name:     entry.name,
+			Method:   zip.Deflate,
+			Modified: archiveEpoch,
+		}
+		hdr.SetMode(archiveMode(entry.info))
+
+		if entry.info.IsDir() {
+			hdr.Name += "/"
+			hdr.Method = zip.Store
+		}
+
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.info.IsDir():
+		case entry.link != "":
+			if _, err := io.WriteString(fw, entry.link); err != nil {
+				return err
+			}
+		default:
+			if err := copyFileTo(fw, entry.path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return zw.Close()
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
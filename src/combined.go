@@ -0,0 +1,165 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// combined - Combined, interleaved log of a branch build
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// combinedLogFile is the name of the combined log within a branch log directory
+const combinedLogFile = "combined.log"
+
+// combinedLog interleaves the output of every script of a branch build in the
+// order it was produced, between begin and end markers for each build step.
+// A nil *combinedLog discards everything, so callers needn't check for one.
+type combinedLog struct {
+	lock      sync.Mutex
+	file      *os.File
+	start     time.Time
+	step      string
+	stepStart time.Time
+}
+
+func newCombinedLog(path string) (*combinedLog, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &combinedLog{file: file, start: time.Now()}, nil
+}
+
+// write adds a line tagged with the time since the branch build started, with
+// any secrets masked, as the combined log is published with the artifacts
+func (c *combinedLog) write(tag string, text string) {
+	fmt.Fprintf(c.file, "+%010.3fs %-4s %s\n", time.Since(c.start).Seconds(), tag, maskSecrets(text))
+}
+
+// Line records a line of output from the given stream of the current step
+func (c *combinedLog) Line(stream string, text string) {
+	if c == nil {
+		return
+	}
+
+	tag := "OUT"
+	if stream == "stderr" {
+		tag = "ERR"
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.write(tag, "| "+text)
+}
+
+// Begin marks the start of a build step, ending any step still open
+func (c *combinedLog) Begin(step string) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.step != "" {
+		c.end("interrupted")
+	}
+
+	c.step = maskSecrets(step)
+	c.stepStart = time.Now()
+	c.write("---", "BEGIN "+c.step)
+}
+
+// End marks the end of the current build step with its outcome
+func (c *combinedLog) End(status string) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.end(status)
+}
+
+func (c *combinedLog) end(status string) {
+	if c.step == "" {
+		return
+	}
+
+	c.write("---", fmt.Sprintf("END %s (%s, %s)", c.step, status, time.Since(c.stepStart)))
+	c.step = ""
+}
+
+// Finish ends any open step as failed when the branch build panicked, then
+// records the overall outcome and closes the log
+func (c *combinedLog) Finish(failure interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if failure != nil {
+		c.end(fmt.Sprintf("failed: %v", failure))
+		c.write("---", fmt.Sprintf("branch build failed after %s", time.Since(c.start)))
+	} else {
+		c.write("---", fmt.Sprintf("branch build completed in %s", time.Since(c.start)))
+	}
+
+	c.file.Close()
+}
+
+// stepStatus describes the outcome of a step for its end marker
+func stepStatus(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return "failed: " + err.Error()
+}
+
+// scriptStatus describes the outcome of a script, including its exit code
+func scriptStatus(err error) string {
+	if code := exitCode(err); code >= 0 {
+		return fmt.Sprintf("exit %d", code)
+	}
+	return "failed: " + err.Error()
+}
+
+// exitCode returns the exit code of a script, or -1 if it could not be run
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,475 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// commands - Sub-commands that run instead of a build
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Danw33/go-build/pluginapi"
+)
+
+// commands maps each sub-command name to its handler, which receives the
+// remaining arguments and returns the process exit code
+var commands = map[string]func([]string) int{
+	"verify":   runVerifyCommand,
+	"gc":       runGCCommand,
+	"secrets":  runSecretsCommand,
+	"validate": runValidateCommand,
+}
+
+// commandArgs returns the arguments given to a sub-command, without the
+// global flags handled in main
+func commandArgs(args []string) []string {
+	var res []string
+	for _, arg := range args {
+		if arg == "-v" || arg == "--verbose" {
+			continue
+		}
+		res = append(res, arg)
+	}
+	return res
+}
+
+// runVerifyCommand checks a published directory against its manifest, and the
+// manifest and any archives against their signatures when a public key is given:
+//
+//	go-build verify [-p <public key file or base64 key>] <dir>
+func runVerifyCommand(args []string) int {
+	var dir, pubKeyArg string
+
+	args = commandArgs(args)
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-p" && i+1 < len(args):
+			i++
+			pubKeyArg = args[i]
+		case dir == "" && !strings.HasPrefix(args[i], "-"):
+			dir = args[i]
+		default:
+			Log.Errorf("verify: unexpected argument \"%s\"", args[i])
+			return 2
+		}
+	}
+
+	if dir == "" {
+		Log.Error("usage: go-build verify [-p <public key>] <dir>")
+		return 2
+	}
+
+	manifest, err := readManifest(dir)
+	if err != nil {
+		Log.Errorf("verify: failed to read manifest: %v", err)
+		return 1
+	}
+
+	Log.Infof("verify: %s, branch %s at commit %s (%s), built %s by %s",
+		manifest.Project, manifest.Branch, manifest.Commit, manifest.Description, manifest.BuildTime, manifest.Version)
+
+	failures := 0
+
+	var pubKey *minisignPublicKey
+	if pubKeyArg != "" {
+		if pubKey, err = readMinisignPublicKey(pubKeyArg); err != nil {
+			Log.Errorf("verify: failed to load public key: %v", err)
+			return 1
+		}
+
+		if err := verifyFileSignature(pubKey, dir+"/"+manifestFile); err != nil {
+			Log.Errorf("verify: manifest signature is invalid: %v", err)
+			return 1
+		}
+		Log.Infof("verify: manifest signature is valid (key ID %s)", keyIDString(pubKey.keyID))
+	} else {
+		Log.Warning("verify: no public key given, signatures will not be checked")
+	}
+
+	listed := make(map[string]bool)
+	for _, f := range manifest.Files {
+		path, err := manifestFilePath(dir, f.Path)
+		if err != nil {
+			Log.Errorf("verify: %v", err)
+			failures++
+			continue
+		}
+		listed[f.Path] = true
+
+		info, err := os.Stat(path)
+		if err != nil {
+			Log.Errorf("verify: %s: %v", f.Path, err)
+			failures++
+			continue
+		}
+
+		if info.Size() != f.Size {
+			Log.Errorf("verify: %s: size is %d, expected %d", f.Path, info.Size(), f.Size)
+			failures++
+			continue
+		}
+
+		sum, err := sha256File(path)
+		if err != nil {
+			Log.Errorf("verify: %s: %v", f.Path, err)
+			failures++
+			continue
+		}
+
+		if sum != f.SHA256 {
+			Log.Errorf("verify: %s: checksum mismatch", f.Path)
+			failures++
+			continue
+		}
+
+		Log.Debugf("verify: %s: OK", f.Path)
+	}
+
+	// Signed files (such as archives) are checked against their detached signatures too
+	if pubKey != nil {
+		for _, f := range manifest.Files {
+			if !strings.HasSuffix(f.Path, signatureSuffix) || !listed[strings.TrimSuffix(f.Path, signatureSuffix)] {
+				continue
+			}
+
+			signed := dir + "/" + filepath.FromSlash(strings.TrimSuffix(f.Path, signatureSuffix))
+			if err := verifyFileSignature(pubKey, signed); err != nil {
+				Log.Errorf("verify: %v", err)
+				failures++
+				continue
+			}
+			Log.Infof("verify: %s: signature is valid", strings.TrimSuffix(f.Path, signatureSuffix))
+		}
+	}
+
+	// Anything not in the manifest can't be trusted, but isn't a failure either
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, relErr := filepath.Rel(dir, path)
+		if relErr == nil && !listed[filepath.ToSlash(rel)] && !manifestReserved(filepath.ToSlash(rel)) {
+			Log.Warningf("verify: %s is not listed in the manifest", filepath.ToSlash(rel))
+		}
+		return nil
+	})
+
+	if failures > 0 {
+		Log.Errorf("verify: %d of %d files failed verification", failures, len(manifest.Files))
+		return 1
+	}
+
+	Log.Infof("verify: all %d files verified", len(manifest.Files))
+	return 0
+}
+
+// runGCCommand removes blobs that are no longer linked from any published
+// directory, and reports how much space deduplication is saving:
+//
+//	go-build gc [--dry-run]
+func runGCCommand(args []string) int {
+	dryRun := false
+	for _, arg := range commandArgs(args) {
+		if arg != "--dry-run" {
+			Log.Errorf("gc: unexpected argument \"%s\"", arg)
+			return 2
+		}
+		dryRun = true
+	}
+
+	config, err := loadCommandConfig()
+	if err != nil {
+		Log.Errorf("gc: failed to load configuration: %v", err)
+		return 1
+	}
+
+	blobs := blobStoreDirectory(config.Home)
+	Log.Infof("gc: collecting unreferenced blobs in \"%s\"", blobs)
+
+	stats, err := collectBlobGarbage(blobs, dryRun)
+	if err != nil {
+		Log.Errorf("gc: %v", err)
+		return 1
+	}
+
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+
+	Log.Infof("gc: %s %d unreferenced blobs, freeing %d bytes", verb, stats.unreferenced, stats.freed)
+	Log.Infof("gc: %d blobs in use, holding %d bytes; deduplication is saving %d bytes", stats.blobs, stats.bytes, stats.saved)
+	return 0
+}
+
+// runSecretsCommand manages the encrypted secrets file. Secrets are global
+// unless a project path is given with -p, and a value that isn't given on the
+// command line is read from the first line of standard input:
+//
+//	go-build secrets set [-p <project>] <name> [<value>]
+//	go-build secrets get [-p <project>] <name>
+//	go-build secrets list [-p <project>]
+func runSecretsCommand(args []string) int {
+	var action, project string
+	var rest []string
+
+	args = commandArgs(args)
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-p" && i+1 < len(args):
+			i++
+			project = args[i]
+		case action == "":
+			action = args[i]
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	usage := "usage: go-build secrets set|get|list [-p <project>] [<name>] [<value>]"
+	if (action == "list" && len(rest) != 0) ||
+		(action == "get" && len(rest) != 1) ||
+		(action == "set" && (len(rest) < 1 || len(rest) > 2)) ||
+		(action != "list" && action != "get" && action != "set") {
+		Log.Error(usage)
+		return 2
+	}
+
+	if len(rest) > 0 && !secretNamePattern.MatchString(rest[0]) {
+		Log.Errorf("secrets: \"%s\" is not a valid name, use letters, digits and underscores", rest[0])
+		return 2
+	}
+
+	config, err := loadCommandConfig()
+	if err != nil {
+		Log.Errorf("secrets: failed to load configuration: %v", err)
+		return 1
+	}
+
+	key, err := readSecretsKey(config.SecretsFile)
+	if err != nil {
+		Log.Errorf("secrets: %v", err)
+		return 1
+	}
+
+	path := secretsPath(config.SecretsFile)
+	secrets := &secretsFile{}
+	if _, err := os.Stat(path); err == nil || action != "set" {
+		if secrets, err = readSecretsFile(path, key); err != nil {
+			Log.Errorf("secrets: %v", err)
+			return 1
+		}
+	}
+
+	switch action {
+	case "list":
+		scope := secrets.scope(project, false)
+		names := make([]string, 0, len(scope))
+		for name := range scope {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(name)
+		}
+
+	case "get":
+		value, ok := secrets.scope(project, false)[rest[0]]
+		if !ok {
+			Log.Errorf("secrets: \"%s\" is not set", rest[0])
+			return 1
+		}
+		fmt.Println(value)
+
+	case "set":
+		var value string
+		if len(rest) == 2 {
+			value = rest[1]
+		} else {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				Log.Errorf("secrets: failed to read a value from standard input: %v", err)
+				return 1
+			}
+			value = strings.TrimRight(line, "\r\n")
+		}
+
+		secrets.scope(project, true)[rest[0]] = value
+		if err := writeSecretsFile(path, key, secrets); err != nil {
+			Log.Errorf("secrets: failed to write \"%s\": %v", path, err)
+			return 1
+		}
+		Log.Infof("secrets: set \"%s\" in \"%s\"", rest[0], path)
+	}
+
+	return 0
+}
+
+// runValidateCommand checks the configuration file: that it parses, that each
+// project has a url, a unique path and branches, that every plugin can be
+// opened and its config sections match the JSON Schema it exposes, if any, and
+// that hook commands are given for known points of the build:
+//
+//	go-build validate
+func runValidateCommand(args []string) int {
+	if len(commandArgs(args)) > 0 {
+		Log.Error("usage: go-build validate")
+		return 2
+	}
+
+	cfgByte, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		Log.Errorf("validate: %v", err)
+		return 1
+	}
+
+	var config Configuration
+	if err := json.Unmarshal(cfgByte, &config); err != nil {
+		Log.Errorf("validate: %s is not valid: %v", configFile, err)
+		return 1
+	}
+	resolvePluginEntries(&config)
+
+	problems := 0
+	problem := func(format string, args ...interface{}) {
+		Log.Errorf("validate: "+format, args...)
+		problems++
+	}
+
+	checkSection := func(schema []byte, section []byte, where string) {
+		if schema == nil || len(section) == 0 {
+			return
+		}
+		found, err := validateSchema(schema, section, where)
+		if err != nil {
+			problem("%s: %v", where, err)
+		}
+		for _, p := range found {
+			problem("%s", p)
+		}
+	}
+
+	// Plugins are opened to read their schemas, but not initialised
+	schemas := map[string][]byte{}
+	opened := map[string]bool{}
+	openSchema := func(entry PluginConfig, where string) bool {
+		if opened[entry.Path] {
+			return true
+		}
+		_, bp, err := openPlugin(entry)
+		if err != nil {
+			problem("%s: %v", where, err)
+			return false
+		}
+		if cs, ok := bp.(pluginapi.ConfigSchema); ok {
+			schemas[entry.Path] = cs.ConfigSchema()
+		}
+		closePlugin(bp)
+		opened[entry.Path] = true
+		return true
+	}
+
+	var names, files []string
+	for i, entry := range config.Plugins {
+		where := fmt.Sprintf("plugins[%d]", i)
+		if entry.Path == "" {
+			problem("%s: no plugin path is given", where)
+			continue
+		}
+
+		for _, name := range names {
+			if name == pluginEntryName(entry) {
+				problem("%s: the name \"%s\" is already used by another plugin", where, name)
+			}
+		}
+		names, files = append(names, pluginEntryName(entry)), append(files, entry.Path)
+
+		if openSchema(entry, where) {
+			checkSection(schemas[entry.Path], entry.Config, where+".config")
+		}
+	}
+
+	found := checkHooks(config.Hooks, "hooks")
+	for i, proj := range config.Projects {
+		found = append(found, checkHooks(proj.Hooks, fmt.Sprintf("projects[%d].hooks", i))...)
+	}
+	for _, p := range found {
+		problem("%s", p)
+	}
+
+	paths := map[string]int{}
+	for i, proj := range config.Projects {
+		where := fmt.Sprintf("projects[%d]", i)
+		if proj.URL == "" {
+			problem("%s: no url is given", where)
+		}
+		if proj.Path == "" {
+			problem("%s: no path is given", where)
+		} else if j, ok := paths[proj.Path]; ok {
+			problem("%s: the path \"%s\" is already used by projects[%d]", where, proj.Path, j)
+		} else {
+			paths[proj.Path] = i
+		}
+		if len(proj.Branches) == 0 {
+			problem("%s: no branches are given", where)
+		}
+
+		for j, entry := range proj.Plugins {
+			pwhere := fmt.Sprintf("%s.plugins[%d]", where, j)
+
+			global := false
+			for k, name := range names {
+				if pluginEntryMatches(entry, name, files[k]) {
+					global = true
+					checkSection(schemas[files[k]], entry.Config, pwhere+".config")
+				}
+			}
+
+			switch {
+			case global:
+			case entry.Disabled:
+				Log.Warningf("validate: %s: disables \"%s\", which is not a global plugin", pwhere, pluginEntryName(entry))
+			case entry.Path == "":
+				problem("%s: \"%s\" is not a global plugin, and no path is given to load it from", pwhere, entry.Name)
+			case openSchema(entry, pwhere):
+				checkSection(schemas[entry.Path], entry.Config, pwhere+".config")
+			}
+		}
+	}
+
+	if problems > 0 {
+		Log.Errorf("validate: %s has %d problems", configFile, problems)
+		return 1
+	}
+
+	Log.Infof("validate: %s is valid, with %d projects and %d plugins", configFile, len(config.Projects), len(opened))
+	return 0
+}
@@ -28,42 +28,154 @@ package main
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/Danw33/go-build/pluginapi"
 )
 
+// configFile is the configuration file read from the working directory
+const configFile = ".build.json"
+
 // Configuration defines the top-level structure used in the configuration file
 type Configuration struct {
-	Home     string          `json:"home"`
-	Async    bool            `json:"async"`
-	Log      LogConfig       `json:"log"`
-	Metrics  bool            `json:"metrics"`
-	RavenDSN string          `json:"ravendsn"`
-	Plugins  []string        `json:"plugins"`
-	Projects []ProjectConfig `json:"projects"`
+	Home        string                  `json:"home"`
+	Async       bool                    `json:"async"`
+	Log         LogConfig               `json:"log"`
+	Metrics     bool                    `json:"metrics"`
+	RavenDSN    string                  `json:"ravendsn"`
+	Reporting   ReportingConfig         `json:"reporting"`
+	Plugins     []PluginConfig          `json:"plugins"`
+	Hooks       map[string][]HookConfig `json:"hooks"`
+	Secrets     []string                `json:"secrets"`
+	SecretsFile SecretsConfig           `json:"secretsFile"`
+	Signing     SigningConfig           `json:"signing"`
+	Storage     StorageConfig           `json:"storage"`
+	Events      EventsConfig            `json:"events"`
+	Tracing     TracingConfig           `json:"tracing"`
+	Projects    []ProjectConfig         `json:"projects"`
+}
+
+// TracingConfig defines where the trace of a run is exported to, if anywhere,
+// and is utilised within the Configuration struct
+type TracingConfig struct {
+	Exporter string            `json:"exporter"`
+	Endpoint string            `json:"endpoint"`
+	Path     string            `json:"path"`
+	Headers  map[string]string `json:"headers"`
+}
+
+// EventsConfig defines where the lifecycle event stream is written, and is
+// utilised within the Configuration struct
+type EventsConfig struct {
+	Path string `json:"path"`
+}
+
+// ReportingConfig defines where errors are reported, if anywhere, and is
+// utilised within the Configuration struct
+type ReportingConfig struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	DSN  string `json:"dsn"`
+}
+
+// SecretsConfig defines the encrypted secrets file and where its key is read
+// from, and is utilised within the Configuration struct
+type SecretsConfig struct {
+	Path    string `json:"path"`
+	KeyEnv  string `json:"keyEnv"`
+	KeyFile string `json:"keyFile"`
+}
+
+// SigningConfig defines the key used to sign published manifests and archives,
+// and is utilised within the Configuration struct
+type SigningConfig struct {
+	Key         string `json:"key"`
+	PasswordEnv string `json:"passwordEnv"`
 }
 
 // LogConfig defines the configuration available for the logger, and is utilised
 // within the Configuration struct
 type LogConfig struct {
-	Level string `json:"level"`
+	Level   string        `json:"level"`
+	Format  string        `json:"format"`
+	File    LogFileConfig `json:"file"`
+	Echo    bool          `json:"echo"`
+	MaxSize int64         `json:"maxSize"`
 }
 
-// ProjectConfig defines the project-level configuration, and is utilised within
-// the Configuration struct
-type ProjectConfig struct {
-	URL       string   `json:"url"`
-	Path      string   `json:"path"`
-	Artifacts string   `json:"artifacts"`
-	Plugins   []string `json:"plugins"`
-	Branches  []string `json:"branches"`
-	Scripts   []string `json:"scripts"`
+// LogFileConfig defines the optional log file and its rotation, and is
+// utilised within the LogConfig struct
+type LogFileConfig struct {
+	Path     string `json:"path"`
+	MaxSize  int64  `json:"maxSize"`
+	MaxFiles int    `json:"maxFiles"`
 }
 
+// StorageConfig defines where build artifacts are published, and is utilised
+// within the Configuration struct
+type StorageConfig struct {
+	Type      string             `json:"type"`
+	PublicURL string             `json:"publicURL"`
+	Local     LocalStorageConfig `json:"local"`
+	S3        S3StorageConfig    `json:"s3"`
+}
+
+// LocalStorageConfig defines the configuration of the local filesystem storage,
+// and is utilised within the StorageConfig struct
+type LocalStorageConfig struct {
+	Path  string `json:"path"`
+	Dedup bool   `json:"dedup"`
+}
+
+// S3StorageConfig defines the configuration of S3-compatible storage, and is
+// utilised within the StorageConfig struct
+type S3StorageConfig struct {
+	Endpoint     string `json:"endpoint"`
+	Region       string `json:"region"`
+	Bucket       string `json:"bucket"`
+	Prefix       string `json:"prefix"`
+	Insecure     bool   `json:"insecure"`
+	AccessKeyEnv string `json:"accessKeyEnv"`
+	SecretKeyEnv string `json:"secretKeyEnv"`
+}
+
+// ProjectConfig, EnvVar, ArchiveConfig, PluginConfig and HookConfig are
+// defined by the plugin API, as plugins receive the configuration of the
+// project they are called for
+type (
+	ProjectConfig = pluginapi.ProjectConfig
+	EnvVar        = pluginapi.EnvVar
+	ArchiveConfig = pluginapi.ArchiveConfig
+	PluginConfig  = pluginapi.PluginConfig
+	HookConfig    = pluginapi.HookConfig
+)
+
 // parseConfig takes the given json string and uses json.Unmarshal to parse it
 // using the Configuration struct
 func parseConfig(cfg string) *Configuration {
 	res := Configuration{}
 	Log.Debug("Parsing Configuration using json.Unmarshal...\n")
 	json.Unmarshal([]byte(cfg), &res)
+	resolvePluginEntries(&res)
 	Log.Debugf("Loaded Configuration: %d Projects Configured.\n", len(res.Projects))
 	return &res
 }
+
+// loadCommandConfig reads and parses the configuration file for a sub-command,
+// resolving the home directory in the same way as a build
+func loadCommandConfig() (*Configuration, error) {
+	cfgByte, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := parseConfig(string(cfgByte))
+	if config.Home == "" || config.Home == "./" {
+		if config.Home, err = os.Getwd(); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
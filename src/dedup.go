@@ -0,0 +1,192 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// dedup - Content-addressed blob store for deduplicating published artifacts
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// blobStoreDirectory is where the content-addressed blobs are kept within home
+func blobStoreDirectory(home string) string {
+	return home + "/blobs/sha256"
+}
+
+// blobPath returns the path of the blob with the given SHA-256, fanned out by
+// the first two hex digits to keep directories small
+func blobPath(blobs string, sum string) string {
+	return blobs + "/" + sum[:2] + "/" + sum
+}
+
+// dedupTree replaces each regular file beneath dir with a hardlink into the blob
+// store, adding new content to the store as it goes. Blobs are made read-only,
+// as every published copy of them shares their inode. The SHA-256 of the files
+// listed in the manifest of dir is taken from it rather than hashed again. It
+// returns the number of files that were already present in the store, and the
+// bytes that saved.
+func dedupTree(dir string, blobs string) (int, int64, error) {
+	linked := 0
+	var saved int64
+
+	sums := manifestSums(dir)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sum, ok := sums[filepath.ToSlash(rel)]
+		if !ok {
+			if sum, err = sha256File(path); err != nil {
+				return err
+			}
+		}
+		blob := blobPath(blobs, sum)
+
+		blobInfo, err := os.Stat(blob)
+		if os.IsNotExist(err) {
+			// New content: the published file becomes the blob
+			if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+				return err
+			}
+			err = os.Link(path, blob)
+			if !os.IsExist(err) && err != nil {
+				return err
+			}
+
+			// Either way, another project may have published the same content first
+			blobInfo, err = os.Stat(blob)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Blobs added before they were made read-only are made so now
+		if blobInfo.Mode()&0222 != 0 {
+			if err := os.Chmod(blob, blobInfo.Mode()&^0222); err != nil {
+				return err
+			}
+			if blobInfo, err = os.Stat(blob); err != nil {
+				return err
+			}
+		}
+
+		// Hardlinks share their mode, so only files with matching permissions
+		// (but for being read-only) are linked
+		if os.SameFile(info, blobInfo) || blobInfo.Mode() != info.Mode()&^0222 {
+			return nil
+		}
+
+		// Link next to the file and rename over it, so the path is never missing
+		tmp := path + ".go-build-dedup"
+		if err := os.Link(blob, tmp); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+
+		linked++
+		saved += info.Size()
+		return nil
+	})
+
+	return linked, saved, err
+}
+
+// manifestSums returns the SHA-256 of each file listed in the manifest of dir,
+// by its path relative to dir, or nil if dir has no manifest
+func manifestSums(dir string) map[string]string {
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return nil
+	}
+
+	sums := make(map[string]string, len(manifest.Files))
+	for _, f := range manifest.Files {
+		sums[f.Path] = f.SHA256
+	}
+	return sums
+}
+
+// blobStoreStats summarises the blob store and what it has saved
+type blobStoreStats struct {
+	blobs        int
+	bytes        int64
+	saved        int64
+	unreferenced int
+	freed        int64
+}
+
+// collectBlobGarbage walks the blob store, removing any blob that is no longer
+// linked from a published directory unless dryRun is set
+func collectBlobGarbage(blobs string, dryRun bool) (blobStoreStats, error) {
+	stats := blobStoreStats{}
+
+	err := filepath.Walk(blobs, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == blobs {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		links, ok := linkCount(info)
+		if !ok {
+			return fmt.Errorf("link counts are not available on this platform")
+		}
+
+		// The store holds one link, every other link is a published copy
+		if links <= 1 {
+			stats.unreferenced++
+			stats.freed += info.Size()
+			if dryRun {
+				return nil
+			}
+			return os.Remove(path)
+		}
+
+		stats.blobs++
+		stats.bytes += info.Size()
+		stats.saved += int64(links-2) * info.Size()
+		return nil
+	})
+
+	return stats, err
+}
@@ -0,0 +1,156 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// events - Machine-readable stream of build lifecycle events
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// eventStream is the configured event stream, or nil when events are disabled
+var eventStream *eventWriter
+
+// buildEvent is a single lifecycle event. Every event carries the run ID, and
+// events of a project or branch carry its path and branch name, so they can be
+// grouped the same way across the whole stream.
+type buildEvent struct {
+	Seq     int64                  `json:"seq"`
+	Time    string                 `json:"time"`
+	Event   string                 `json:"event"`
+	Run     string                 `json:"run"`
+	Project string                 `json:"project,omitempty"`
+	Branch  string                 `json:"branch,omitempty"`
+	Script  *int                   `json:"script,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// eventWriter writes events as NDJSON, one event per line
+type eventWriter struct {
+	lock   sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	seq    int64
+	failed bool
+}
+
+// openEventStream opens the configured event stream: "-" for standard output,
+// otherwise a file (appended to) or a FIFO. Opening a FIFO waits for a reader.
+func openEventStream(cfg EventsConfig) error {
+	if cfg.Path == "" {
+		return nil
+	}
+
+	if cfg.Path == "-" {
+		// Anything else written to standard output, such as by plugins, goes to
+		// standard error along with the console log
+		eventStream = &eventWriter{w: os.Stdout}
+		os.Stdout = os.Stderr
+		return nil
+	}
+
+	Log.Infof("Writing lifecycle events to \"%s\"", cfg.Path)
+	file, err := os.OpenFile(cfg.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	eventStream = &eventWriter{w: file, closer: file}
+	return nil
+}
+
+// closeEventStream closes the event stream at the end of the run
+func closeEventStream() {
+	if eventStream == nil || eventStream.closer == nil {
+		return
+	}
+
+	eventStream.lock.Lock()
+	defer eventStream.lock.Unlock()
+	eventStream.closer.Close()
+	eventStream.failed = true
+}
+
+// emitEvent writes an event to the stream, if one is configured. Strings in the
+// event data are masked. A stream that fails (e.g. a FIFO whose reader went
+// away) is disabled rather than failing the build.
+func emitEvent(event string, project string, branch string, data map[string]interface{}) {
+	emitScriptEvent(event, project, branch, -1, data)
+}
+
+// emitScriptEvent is emitEvent for events of the script of the given index
+func emitScriptEvent(event string, project string, branch string, script int, data map[string]interface{}) {
+	if eventStream == nil {
+		return
+	}
+
+	for k, v := range data {
+		switch val := v.(type) {
+		case string:
+			data[k] = maskSecrets(val)
+		case []string:
+			masked := make([]string, len(val))
+			for i, s := range val {
+				masked[i] = maskSecrets(s)
+			}
+			data[k] = masked
+		}
+	}
+
+	ev := buildEvent{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Event:   event,
+		Run:     runID,
+		Project: project,
+		Branch:  branch,
+		Data:    data,
+	}
+	if script >= 0 {
+		ev.Script = &script
+	}
+
+	eventStream.lock.Lock()
+	defer eventStream.lock.Unlock()
+
+	if eventStream.failed {
+		return
+	}
+
+	eventStream.seq++
+	ev.Seq = eventStream.seq
+
+	line, err := json.Marshal(ev)
+	if err == nil {
+		_, err = eventStream.w.Write(append(line, '\n'))
+	}
+	if err != nil {
+		eventStream.failed = true
+		Log.Errorf("Failed to write lifecycle event, the event stream has been disabled: %v", err)
+	}
+}
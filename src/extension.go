@@ -1,6 +1,7 @@
 package main
 
-// BuildPlugin defines a common interface for go-build plugins
+// BuildPlugin defines the version 1 interface for go-build plugins, which is
+// still loaded through an adapter; new plugins should use pluginapi.Plugin
 type BuildPlugin interface {
 	// pluginInit 0. Plugin Initialiser, called on load of plugin file
 	// receives the raw configuration as a byte array (to be parsed with json.Unmarshal)
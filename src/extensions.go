@@ -27,156 +27,660 @@ SOFTWARE.
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"plugin"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/getsentry/raven-go"
+	"github.com/Danw33/go-build/pluginapi"
+)
+
+const (
+	// serialDispatch is the dispatch mode of plugins whose hooks run one at a time
+	serialDispatch = "serial"
+
+	// concurrentDispatch is the dispatch mode of concurrent-safe plugins
+	concurrentDispatch = "concurrent"
 )
 
 // loadedPlugins contains the raw plugins loaded from the filesystem
 var loadedPlugins []*plugin.Plugin
 
-// buildPlugins contains the actual BuildPlugin symbols for each loaded plugin
-var buildPlugins []BuildPlugin
+// loadedPluginFiles contains the file name of each of the loadedPlugins
+var loadedPluginFiles []string
+
+// buildPlugins contains the initialised plugins, version 1 plugins wrapped in a v1Plugin
+var buildPlugins []pluginapi.Plugin
+
+// buildPluginNames contains the name of each of the buildPlugins
+var buildPluginNames []string
+
+// buildPluginFiles contains the file name of each of the buildPlugins
+var buildPluginFiles []string
+
+// buildPluginLocks contains the lock serializing the hooks of each of the
+// buildPlugins, or nil for plugins that are concurrent-safe
+var buildPluginLocks []*sync.Mutex
+
+// runContext is passed to the run-level hooks, and is the root of every other
+// hook context
+var runContext = &pluginapi.RunContext{Context: context.Background(), ID: runID, Version: Version, BuildTime: BuildTime}
+
+// cancelRun cancels the context of the run
+var cancelRun = func() {}
+
+// startRun creates the context of the run, which is cancelled if it is aborted
+func startRun(config *Configuration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runContext = &pluginapi.RunContext{
+		Context:    ctx,
+		ID:         runID,
+		Version:    Version,
+		BuildTime:  BuildTime,
+		WorkingDir: pwd,
+		HomeDir:    config.Home,
+		Async:      config.Async,
+	}
+	cancelRun = cancel
+}
+
+// abortRun cancels the run: running scripts are killed, and no further
+// projects or branches are started
+func abortRun(err error) {
+	Log.Errorf("Aborting the run: %v", err)
+	cancelRun()
+}
+
+// runAborted returns whether the run has been aborted
+func runAborted() bool {
+	return runContext.Context.Err() != nil
+}
 
 // loadPlugins is responsible for reading, testing, and initialising plugins that
 // have been defined in the configuration file.
 func loadPlugins(config *Configuration, rawCfg []byte) {
+	plog := projectLog("").withPhase("plugin")
+
+	// Version 1 plugins are initialised with the whole configuration
+	pluginConfig = rawCfg
 
 	// See if the config defines any plugins
 	if len(config.Plugins) == 0 {
-		Log.Info("No plugins configured, bypassing plugin loader.")
+		plog.Infof("No plugins configured, bypassing plugin loader.")
 		return
 	}
 
-	// Load in plugin files
-	for _, pFile := range config.Plugins {
-		raven.CapturePanic(func() {
-			if plug, err := plugin.Open(pFile); err == nil {
-				loadedPlugins = append(loadedPlugins, plug)
-			} else {
-				Log.Criticalf("Failed to load plugin \"%s\"", pFile)
-				Log.Critical(err)
-			}
-		}, nil)
+	// Load, check and initialise each plugin file with its own config section
+	pluginsMu.Lock()
+	for _, entry := range config.Plugins {
+		if entry.Disabled {
+			plog.Infof("Plugin \"%s\" is disabled", pluginEntryName(entry))
+			continue
+		}
+		if _, ok := loadPlugin(entry, maskedConfig(entry.Config), plog); !ok {
+			failedPlugins[entry.Path] = true
+		}
 	}
+	globalPlugins = len(buildPlugins)
+	pluginsMu.Unlock()
 
-	// See if we loaded any plugins from the disk
-	if len(loadedPlugins) == 0 {
-		Log.Info("No configured plugins could be loaded.")
+	// See if we loaded any plugins, from the disk or compiled in
+	if len(buildPlugins) == 0 {
+		plog.Infof("No configured plugins could be loaded.")
 		return
 	}
 
-	// For each plugin we loaded, Locate BuildPlugin symbol and check it
-	for _, p := range loadedPlugins {
-		// Lookup the symbol
-		sym, err := p.Lookup("BuildPlugin")
+	// Log plugin status now loading is completed
+	plog.Debugf("Plugin Loader: %d found in config, %d loaded from filesystem, %d compatible and initialised.", len(config.Plugins), len(loadedPlugins), len(buildPlugins))
+	if compiled := pluginapi.RegisteredNames(); len(compiled) > 0 {
+		plog.Debugf("Plugin Loader: plugins compiled in: %s", strings.Join(compiled, ", "))
+	}
+	plog.Infof("Initialised %d plugins successfully", len(buildPlugins))
+}
+
+// loadPlugin opens a plugin and initialises it with its config section,
+// returning its index in buildPlugins. pluginsMu must be held
+func loadPlugin(entry PluginConfig, config []byte, plog fieldLogger) (int, bool) {
+	pFile, name := entry.Path, pluginEntryName(entry)
+	if entry.Dispatch != "" && entry.Dispatch != serialDispatch && entry.Dispatch != concurrentDispatch {
+		plog.Errorf("Plugin \"%s\" is not loaded, its dispatch mode \"%s\" is not %s or %s", pFile, entry.Dispatch, serialDispatch, concurrentDispatch)
+		return -1, false
+	}
+	for _, loaded := range buildPluginNames {
+		if loaded == name {
+			plog.Errorf("Plugin \"%s\" is not loaded, the name \"%s\" is already used by another plugin", pFile, name)
+			return -1, false
+		}
+	}
+
+	p, bp, err := openPlugin(entry)
+	if p != nil {
+		loadedPlugins = append(loadedPlugins, p)
+		loadedPluginFiles = append(loadedPluginFiles, pFile)
+	}
+	if err != nil {
+		reportError(err)
+		plog.Errorf("%v", err)
+		return -1, false
+	}
+
+	if v1, ok := bp.(v1Plugin); ok {
+		plog.Warningf("Plugin \"%s\" uses the version 1 plugin interface, which is deprecated", pFile)
+		v1.config = pluginConfig
+		bp = v1
+	}
+
+	// Call the pluginInit for the current plugin
+	initErr := bp.PluginInit(runContext.Context, config)
+	if initErr != nil {
+		reportError(initErr)
+		plog.Errorf("Plugin loaded but failed to initialise: %v", initErr)
+		closePlugin(bp)
+		return -1, false
+	}
+
+	// Its template variables and functions must not clash with any others
+	if tmplErr := registerTemplates(name, bp); tmplErr != nil {
+		reportError(tmplErr)
+		plog.Errorf("Plugin \"%s\" is not loaded: %v", pFile, tmplErr)
+		closePlugin(bp)
+		return -1, false
+	}
+
+	// Add it to the array of initialised plugins
+	buildPlugins = append(buildPlugins, bp)
+	buildPluginNames = append(buildPluginNames, name)
+	buildPluginFiles = append(buildPluginFiles, pFile)
+	buildPluginLocks = append(buildPluginLocks, dispatchLock(entry, bp, plog))
+	return len(buildPlugins) - 1, true
+}
+
+// dispatchLock returns the lock serializing the hooks of a plugin, or nil if
+// it declares itself concurrent-safe, either as its entry gives
+func dispatchLock(entry PluginConfig, bp pluginapi.Plugin, plog fieldLogger) *sync.Mutex {
+	concurrent := false
+	if cs, ok := bp.(pluginapi.ConcurrentSafe); ok {
+		concurrent = cs.ConcurrentSafe()
+	}
+	switch entry.Dispatch {
+	case serialDispatch:
+		concurrent = false
+	case concurrentDispatch:
+		concurrent = true
+	}
+
+	if concurrent {
+		plog.Debugf("Plugin \"%s\" hooks are dispatched concurrently", pluginEntryName(entry))
+		return nil
+	}
+	return &sync.Mutex{}
+}
+
+// openPlugin opens a plugin without initialising it: it starts the process of
+// an executable plugin, or opens a Go plugin file, unless a plugin compiled in
+// is registered under its path, and checks its BuildPlugin symbol implements
+// either version of the plugin interface
+func openPlugin(entry PluginConfig) (*plugin.Plugin, pluginapi.Plugin, error) {
+	pFile := entry.Path
+	switch entry.Type {
+	case "", goPluginType:
+		if sym, ok := pluginapi.Registered(pFile); ok {
+			bp, err := pluginSymbol(pFile, sym)
+			return nil, bp, err
+		}
+	case rpcPluginType:
+		rp, err := startRPCPlugin(entry)
 		if err != nil {
-			raven.CaptureError(err, nil)
-			Log.Errorf("Plugin exports no BuildPlugin symbol: %v", err)
-			continue
+			return nil, nil, err
 		}
+		return nil, rp, nil
+	default:
+		return nil, nil, fmt.Errorf("plugin \"%s\" has unknown type \"%s\"", pFile, entry.Type)
+	}
 
-		// Check it's compatible with our definition of the BuildPlugin interface
-		bp, ok := sym.(BuildPlugin)
-		if !ok {
-			Log.Errorf("Build Plugin is not an BuildPlugin interface type")
-			continue
+	var p *plugin.Plugin
+	var err error
+	if r := reportPanic(func() { p, err = plugin.Open(pFile) }); r != nil {
+		err = hookPanic(r)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load plugin \"%s\": %v", pFile, err)
+	}
+
+	// Lookup the symbol
+	sym, err := p.Lookup("BuildPlugin")
+	if err != nil {
+		return p, nil, fmt.Errorf("plugin \"%s\" exports no BuildPlugin symbol: %v", pFile, err)
+	}
+
+	bp, err := pluginSymbol(pFile, sym)
+	return p, bp, err
+}
+
+// pluginSymbol checks the BuildPlugin symbol of a plugin implements either
+// version of the plugin interface
+func pluginSymbol(pFile string, sym interface{}) (pluginapi.Plugin, error) {
+	switch s := sym.(type) {
+	case pluginapi.Plugin:
+		return s, nil
+	case BuildPlugin:
+		return v1Plugin{plugin: s}, nil
+	}
+	return nil, fmt.Errorf("plugin \"%s\" BuildPlugin is not a pluginapi.Plugin or BuildPlugin interface type", pFile)
+}
+
+// closePlugin stops a plugin that runs in its own process
+func closePlugin(bp pluginapi.Plugin) {
+	if c, ok := bp.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			reportError(err)
+			Log.Error(err)
 		}
+	}
+}
 
-		// Call the pluginInit for the current plugin
-		initErr := bp.PluginInit(rawCfg)
-		if initErr != nil {
-			raven.CaptureError(initErr, nil)
-			Log.Errorf("Plugin loaded but failed to initialise: %v", initErr)
-			continue
+// closePlugins stops every loaded plugin that runs in its own process, at the
+// end of the run
+func closePlugins() {
+	for _, i := range allPlugins() {
+		bp, _ := pluginAt(i)
+		closePlugin(bp)
+	}
+}
+
+// pluginEntryName returns the name of a plugin list entry, which defaults to
+// the name of its file
+func pluginEntryName(entry PluginConfig) string {
+	if entry.Name != "" {
+		return entry.Name
+	}
+	return pluginName(entry.Path)
+}
+
+// maskedConfig returns a config section with any secrets masked, or nil if it is empty
+func maskedConfig(config []byte) []byte {
+	if len(config) == 0 {
+		return nil
+	}
+	return []byte(maskSecrets(string(config)))
+}
+
+// maskingPlugin is implemented by the plugins of go-build itself that pass the
+// contexts of hooks on elsewhere, and mask them as they do so. Shell hooks need
+// the real env of a project to run their commands with
+type maskingPlugin interface {
+	masksSecrets()
+}
+
+// projectView returns the context of a project hook as a plugin sees it, with
+// the secrets in the project configuration masked, and a function taking back
+// the changes it made once the hook returns
+func projectView(p pluginapi.Plugin, pc *pluginapi.ProjectContext) (*pluginapi.ProjectContext, func()) {
+	if _, ok := p.(maskingPlugin); ok {
+		return pc, func() {}
+	}
+	sent := maskedProject(pc.Project)
+	view := &pluginapi.ProjectContext{RunContext: pc.RunContext, Project: maskedProject(pc.Project), Dir: pc.Dir}
+	return view, func() { unmaskProject(pc.Project, sent, view.Project) }
+}
+
+// branchView is projectView for the context of a branch hook
+func branchView(p pluginapi.Plugin, bc *pluginapi.BranchContext) (*pluginapi.BranchContext, func()) {
+	if _, ok := p.(maskingPlugin); ok {
+		return bc, func() {}
+	}
+	pv, unmask := projectView(p, bc.ProjectContext)
+	description := maskSecrets(bc.Description)
+	view := &pluginapi.BranchContext{ProjectContext: pv, Name: bc.Name, Commit: bc.Commit, Description: description, Published: bc.Published}
+	return view, func() {
+		unmask()
+		if view.Description != description {
+			bc.Description = view.Description
 		}
+	}
+}
 
-		// Add it to the array of initialised plugins
-		buildPlugins = append(buildPlugins, bp)
+// scriptView is projectView for the context of a script hook, which also has
+// the command and env of the script masked
+func scriptView(p pluginapi.Plugin, sc *pluginapi.ScriptContext) (*pluginapi.ScriptContext, func()) {
+	if _, ok := p.(maskingPlugin); ok {
+		return sc, func() {}
 	}
+	bv, unmask := branchView(p, sc.BranchContext)
+	command := maskSecrets(sc.Command)
+	sentEnv := maskValue(reflect.ValueOf(sc.Env)).Interface().([]string)
 
-	// Log plugin status now loading is completed
-	Log.Debugf("Plugin Loader: %d found in config, %d loaded from filesystem, %d compatible and initialised.", len(config.Plugins), len(loadedPlugins), len(buildPlugins))
-	Log.Infof("Initialised %d plugins successfully", len(buildPlugins))
+	// The plugin gets its own copy, as it may change the env in place
+	view := &pluginapi.ScriptContext{BranchContext: bv, Index: sc.Index, Command: command,
+		Env: append(sentEnv[:0:0], sentEnv...), StdoutLog: sc.StdoutLog, StderrLog: sc.StderrLog, ExitCode: sc.ExitCode}
+	return view, func() {
+		unmask()
+		if view.Command != command {
+			sc.Command = view.Command
+		}
+		sc.Env = unmaskStrings(sc.Env, sentEnv, view.Env)
+	}
 }
 
-// postLoadPlugins (1) is the first fully-loaded hook, after all plugins are loaded
-func runPostLoadPlugins(version *string, buildTime *string) {
-	for _, lp := range buildPlugins {
-		raven.CapturePanic(func() {
-			lp.PostLoadPlugins(version, buildTime)
-		}, nil)
+// artifactsView is projectView for the context of an artifacts hook
+func artifactsView(p pluginapi.Plugin, ac *pluginapi.ArtifactsContext) (*pluginapi.ArtifactsContext, func()) {
+	if _, ok := p.(maskingPlugin); ok {
+		return ac, func() {}
+	}
+	bv, unmask := branchView(p, ac.BranchContext)
+	path := maskSecrets(ac.Path)
+	view := &pluginapi.ArtifactsContext{BranchContext: bv, Path: path, URL: maskSecrets(ac.URL)}
+	return view, func() {
+		unmask()
+		if view.Path != path {
+			ac.Path = view.Path
+		}
 	}
 }
 
+// postLoadPlugins (1) is the first fully-loaded hook, after all plugins are loaded
+func runPostLoadPlugins(parent *span, plugins []int, run *pluginapi.RunContext) error {
+	return dispatchHook(parent, projectLog(""), "PostLoadPlugins", plugins, func(p pluginapi.Plugin) func() error {
+		if h, ok := p.(pluginapi.PostLoadPluginsHook); ok {
+			return func() error { return h.PostLoadPlugins(run) }
+		}
+		return nil
+	})
+}
+
 // preProcessProjects (2) is run before processing all projects
-func runPreProcessProjects(workingDir *string, homeDir *string, async *bool) {
-	for _, lp := range buildPlugins {
-		raven.CapturePanic(func() {
-			lp.PreProcessProjects(workingDir, homeDir, async)
-		}, nil)
-	}
+func runPreProcessProjects(parent *span, plugins []int, run *pluginapi.RunContext) error {
+	return dispatchHook(parent, projectLog(""), "PreProcessProjects", plugins, func(p pluginapi.Plugin) func() error {
+		if h, ok := p.(pluginapi.PreProcessProjectsHook); ok {
+			return func() error { return h.PreProcessProjects(run) }
+		}
+		return nil
+	})
 }
 
 // postProcessProjects (9) is run after processing all projects
-func runPostProcessProjects(workingDir *string, homeDir *string, async *bool) {
-	for _, lp := range buildPlugins {
-		raven.CapturePanic(func() {
-			lp.PostProcessProjects(workingDir, homeDir, async)
-		}, nil)
+func runPostProcessProjects(parent *span, plugins []int, run *pluginapi.RunContext, result *pluginapi.Result) error {
+	return dispatchHook(parent, projectLog(""), "PostProcessProjects", plugins, func(p pluginapi.Plugin) func() error {
+		if h, ok := p.(pluginapi.PostProcessProjectsHook); ok {
+			return func() error { return h.PostProcessProjects(run, result) }
+		}
+		return nil
+	})
+}
+
+// configureProject (3) passes each plugin active for a project its config section
+// from the plugin list of the project
+func runConfigureProject(parent *span, pc *pluginapi.ProjectContext) error {
+	var worst error
+	for _, i := range activePlugins(pc.Project) {
+		config := projectPluginConfig(pc.Project, i)
+		err := dispatchHook(parent, projectLog(pc.Project.Path), "ConfigureProject", []int{i}, func(p pluginapi.Plugin) func() error {
+			if h, ok := p.(pluginapi.ConfigureProjectHook); ok {
+				return func() error {
+					view, unmask := projectView(p, pc)
+					defer unmask()
+					return h.ConfigureProject(view, config)
+				}
+			}
+			return nil
+		})
+		worst = worseError(worst, err)
 	}
+	return worst
 }
 
 // preProcessProject (3) is run before processing an individual project
-func runPreProcessProject(url *string, path *string, artifacts *string, branches *[]string, scripts *[]string) {
-	for _, lp := range buildPlugins {
-		raven.CapturePanic(func() {
-			lp.PreProcessProject(url, path, artifacts, branches, scripts)
-		}, nil)
-	}
+func runPreProcessProject(parent *span, pc *pluginapi.ProjectContext) error {
+	return dispatchHook(parent, projectLog(pc.Project.Path), "PreProcessProject", activePlugins(pc.Project), func(p pluginapi.Plugin) func() error {
+		if h, ok := p.(pluginapi.PreProcessProjectHook); ok {
+			return func() error {
+				view, unmask := projectView(p, pc)
+				defer unmask()
+				return h.PreProcessProject(view)
+			}
+		}
+		return nil
+	})
 }
 
 // postProcessProject (8) is run after processing an individual project
-func runPostProcessProject(url *string, path *string, artifacts *string, branches *[]string, scripts *[]string) {
-	for _, lp := range buildPlugins {
-		raven.CapturePanic(func() {
-			lp.PostProcessProject(url, path, artifacts, branches, scripts)
-		}, nil)
-	}
+func runPostProcessProject(parent *span, pc *pluginapi.ProjectContext, result *pluginapi.Result) error {
+	return dispatchHook(parent, projectLog(pc.Project.Path), "PostProcessProject", activePlugins(pc.Project), func(p pluginapi.Plugin) func() error {
+		if h, ok := p.(pluginapi.PostProcessProjectHook); ok {
+			return func() error {
+				view, unmask := projectView(p, pc)
+				defer unmask()
+				return h.PostProcessProject(view, result)
+			}
+		}
+		return nil
+	})
 }
 
 // preProcessBranch (4) is run before processing a branch within a project
-func runPreProcessBranch(projectDir *string, branchName *string, workDirDesc *string) {
-	for _, lp := range buildPlugins {
-		raven.CapturePanic(func() {
-			lp.PreProcessBranch(projectDir, branchName, workDirDesc)
-		}, nil)
-	}
+func runPreProcessBranch(parent *span, bc *pluginapi.BranchContext) error {
+	return dispatchHook(parent, projectLog(bc.Project.Path).withBranch(bc.Name), "PreProcessBranch", activePlugins(bc.Project), func(p pluginapi.Plugin) func() error {
+		if h, ok := p.(pluginapi.PreProcessBranchHook); ok {
+			return func() error {
+				view, unmask := branchView(p, bc)
+				defer unmask()
+				return h.PreProcessBranch(view)
+			}
+		}
+		return nil
+	})
 }
 
 // postProcessBranch (7) is run after processing a branch within a project
-func runPostProcessBranch(projectDir *string, branchName *string, workDirDesc *string) {
-	for _, lp := range buildPlugins {
-		raven.CapturePanic(func() {
-			lp.PostProcessBranch(projectDir, branchName, workDirDesc)
-		}, nil)
-	}
+func runPostProcessBranch(parent *span, bc *pluginapi.BranchContext, result *pluginapi.Result) error {
+	return dispatchHook(parent, projectLog(bc.Project.Path).withBranch(bc.Name), "PostProcessBranch", activePlugins(bc.Project), func(p pluginapi.Plugin) func() error {
+		if h, ok := p.(pluginapi.PostProcessBranchHook); ok {
+			return func() error {
+				view, unmask := branchView(p, bc)
+				defer unmask()
+				return h.PostProcessBranch(view, result)
+			}
+		}
+		return nil
+	})
+}
+
+// preScript (4a) is run before running each script of a branch
+func runPreScript(parent *span, sc *pluginapi.ScriptContext) error {
+	return dispatchHook(parent, projectLog(sc.Project.Path).withBranch(sc.Name).withScript(sc.Index), "PreScript", activePlugins(sc.Project), func(p pluginapi.Plugin) func() error {
+		if h, ok := p.(pluginapi.PreScriptHook); ok {
+			return func() error {
+				view, unmask := scriptView(p, sc)
+				defer unmask()
+				return h.PreScript(view)
+			}
+		}
+		return nil
+	})
+}
+
+// postScript (4b) is run after running each script of a branch
+func runPostScript(parent *span, sc *pluginapi.ScriptContext, result *pluginapi.Result) error {
+	return dispatchHook(parent, projectLog(sc.Project.Path).withBranch(sc.Name).withScript(sc.Index), "PostScript", activePlugins(sc.Project), func(p pluginapi.Plugin) func() error {
+		if h, ok := p.(pluginapi.PostScriptHook); ok {
+			return func() error {
+				view, unmask := scriptView(p, sc)
+				defer unmask()
+				return h.PostScript(view, result)
+			}
+		}
+		return nil
+	})
 }
 
 // preProcessArtifacts (5) is run before processing the build artifacts of a branch
-func runPreProcessArtifacts(artifactPath *string, projectPath *string, branchName *string) {
-	for _, lp := range buildPlugins {
-		raven.CapturePanic(func() {
-			lp.PreProcessArtifacts(artifactPath, projectPath, branchName)
-		}, nil)
-	}
+func runPreProcessArtifacts(parent *span, ac *pluginapi.ArtifactsContext) error {
+	return dispatchHook(parent, projectLog(ac.Project.Path).withBranch(ac.Name), "PreProcessArtifacts", activePlugins(ac.Project), func(p pluginapi.Plugin) func() error {
+		if h, ok := p.(pluginapi.PreProcessArtifactsHook); ok {
+			return func() error {
+				view, unmask := artifactsView(p, ac)
+				defer unmask()
+				return h.PreProcessArtifacts(view)
+			}
+		}
+		return nil
+	})
 }
 
 // postProcessArtifacts (6) is run after processing the build artifacts of a branch
-func runPostProcessArtifacts(artifactPath *string, projectPath *string, branchName *string) {
-	for _, lp := range buildPlugins {
-		raven.CapturePanic(func() {
-			lp.PostProcessArtifacts(artifactPath, projectPath, branchName)
-		}, nil)
+func runPostProcessArtifacts(parent *span, ac *pluginapi.ArtifactsContext, result *pluginapi.Result) error {
+	return dispatchHook(parent, projectLog(ac.Project.Path).withBranch(ac.Name), "PostProcessArtifacts", activePlugins(ac.Project), func(p pluginapi.Plugin) func() error {
+		if h, ok := p.(pluginapi.PostProcessArtifactsHook); ok {
+			return func() error {
+				view, unmask := artifactsView(p, ac)
+				defer unmask()
+				return h.PostProcessArtifacts(view, result)
+			}
+		}
+		return nil
+	})
+}
+
+// dispatchHook calls a hook on each of the given plugins implementing it, which
+// bind returns the call of, or nil. Errors returned by the plugins (and their
+// panics, as warnings) are logged and reported, and the most severe is returned
+func dispatchHook(parent *span, hlog fieldLogger, hook string, plugins []int, bind func(p pluginapi.Plugin) func() error) error {
+	hlog = hlog.withPhase("plugin")
+
+	var worst error
+	for _, i := range plugins {
+		bp, name := pluginAt(i)
+		if f, ok := bp.(hookFilter); ok && !f.implements(hook) {
+			continue
+		}
+
+		call := bind(bp)
+		if call == nil {
+			continue
+		}
+
+		// Hooks of plugins that aren't concurrent-safe run one at a time
+		lock := pluginLock(i)
+		if lock != nil {
+			lock.Lock()
+		}
+
+		hspan := startHookSpan(parent, hook, name)
+		var err error
+		r := reportPanic(func() { err = call() })
+		if lock != nil {
+			lock.Unlock()
+		}
+		if r != nil {
+			err = hookPanic(r)
+		}
+		if err == nil {
+			hspan.End(nil)
+			continue
+		}
+
+		severity := pluginapi.SeverityOf(err)
+		err = &pluginapi.Error{Severity: severity, Err: fmt.Errorf("plugin \"%s\" %s: %v", name, hook, err)}
+		hspan.SetAttr("severity", severity.String())
+		hspan.End(err)
+
+		// Panics have been reported by reportPanic already, and skipping is no error
+		if r == nil && severity != pluginapi.SeveritySkip {
+			reportError(err)
+		}
+		switch severity {
+		case pluginapi.SeverityWarn:
+			hlog.Warningf("%v", err)
+		case pluginapi.SeveritySkip:
+			hlog.Infof("%v (%s)", err, severity)
+		default:
+			hlog.Errorf("%v (%s)", err, severity)
+		}
+
+		worst = worseError(worst, err)
+	}
+
+	return worst
+}
+
+// hookFilter is implemented by plugins that declare which hooks they implement,
+// as executable plugins do, so others aren't dispatched to them
+type hookFilter interface {
+	implements(hook string) bool
+}
+
+// worseError returns the more severe of two errors returned by hooks, or the
+// first if they are as severe
+func worseError(a error, b error) error {
+	if a == nil || (b != nil && pluginapi.SeverityOf(b) > pluginapi.SeverityOf(a)) {
+		return b
+	}
+	return a
+}
+
+// hookFailed acts on the error returned by dispatching a hook, cancelling the
+// run if it was aborted, and returns whether the work the hook was called for
+// has failed. Warnings have already been logged by dispatchHook
+func hookFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch pluginapi.SeverityOf(err) {
+	case pluginapi.SeverityAbortRun:
+		abortRun(err)
+		return true
+	case pluginapi.SeverityFailBranch:
+		return true
+	}
+	return false
+}
+
+// hookSkipped returns whether the error returned by dispatching a hook is the
+// decision to skip the work it was called for, once hookFailed is false
+func hookSkipped(err error) bool {
+	return err != nil && pluginapi.SeverityOf(err) == pluginapi.SeveritySkip
+}
+
+// runHookFailed acts on the error returned by dispatching a run-level hook,
+// where failing the run is the same as aborting it, and returns whether the
+// run has been aborted
+func runHookFailed(err error) bool {
+	if hookFailed(err) && !runAborted() {
+		abortRun(err)
+	}
+	return runAborted()
+}
+
+// hookResult returns the result passed to the post-processing hooks of work
+// that took the given time, and failed with err if it isn't nil, or was skipped
+// if err is the decision of a hook to skip it
+func hookResult(err error, took time.Duration) *pluginapi.Result {
+	if hookSkipped(err) {
+		return &pluginapi.Result{Status: pluginapi.StatusSkipped, Err: err, Duration: took}
+	}
+	if err != nil {
+		return &pluginapi.Result{Status: pluginapi.StatusFailed, Err: err, Duration: took}
+	}
+	return &pluginapi.Result{Status: pluginapi.StatusSucceeded, Duration: took}
+}
+
+// startHookSpan starts the span of a plugin hook invocation
+func startHookSpan(parent *span, hook string, name string) *span {
+	hspan := startSpan(parent, "plugin "+hook)
+	hspan.SetAttr("hook", hook)
+	hspan.SetAttr("plugin", name)
+	return hspan
+}
+
+// hookPanic returns the panic recovered from a plugin hook as an error
+func hookPanic(recovered interface{}) error {
+	if recovered == nil {
+		return nil
 	}
+	return fmt.Errorf("plugin panicked: %v", recovered)
 }
@@ -0,0 +1,144 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// fields - Structured fields for log records
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// logFields are the structured fields of a log record, rendered as a
+// "[project/branch]" prefix in text logs and as separate fields in JSON logs
+type logFields struct {
+	Project  string
+	Branch   string
+	Phase    string
+	Script   int
+	Duration time.Duration
+}
+
+// fieldMessage is a log record argument carrying a message and its fields
+type fieldMessage struct {
+	fields  logFields
+	message string
+}
+
+// String renders the message for text logs
+func (m *fieldMessage) String() string {
+	scope := m.fields.Project
+	if m.fields.Branch != "" {
+		scope += "/" + m.fields.Branch
+	}
+	if scope == "" {
+		return m.message
+	}
+	return " [" + scope + "] - " + m.message
+}
+
+// fieldLogger logs through Log with a set of fields attached to every record
+type fieldLogger struct {
+	fields logFields
+}
+
+// projectLog returns a logger for the given project
+func projectLog(project string) fieldLogger {
+	return fieldLogger{logFields{Project: project, Script: -1}}
+}
+
+// withBranch returns a copy of the logger for the given branch
+func (l fieldLogger) withBranch(branch string) fieldLogger {
+	l.fields.Branch = branch
+	return l
+}
+
+// withPhase returns a copy of the logger for the given phase of the build,
+// one of clone, fetch, checkout, pull, script, artifacts or plugin
+func (l fieldLogger) withPhase(phase string) fieldLogger {
+	l.fields.Phase = phase
+	return l
+}
+
+// withScript returns a copy of the logger for the script of the given index
+func (l fieldLogger) withScript(index int) fieldLogger {
+	l.fields.Phase = "script"
+	l.fields.Script = index
+	return l
+}
+
+// withDuration returns a copy of the logger recording how long a step took
+func (l fieldLogger) withDuration(d time.Duration) fieldLogger {
+	l.fields.Duration = d
+	return l
+}
+
+func (l fieldLogger) log(level logging.Level, format string, args []interface{}) {
+	if !Log.IsEnabledFor(level) {
+		return
+	}
+
+	msg := &fieldMessage{l.fields, fmt.Sprintf(format, args...)}
+	switch level {
+	case logging.CRITICAL:
+		Log.Critical(msg)
+	case logging.ERROR:
+		Log.Error(msg)
+	case logging.WARNING:
+		Log.Warning(msg)
+	case logging.NOTICE:
+		Log.Notice(msg)
+	case logging.INFO:
+		Log.Info(msg)
+	default:
+		Log.Debug(msg)
+	}
+}
+
+func (l fieldLogger) Criticalf(format string, args ...interface{}) {
+	l.log(logging.CRITICAL, format, args)
+}
+
+func (l fieldLogger) Errorf(format string, args ...interface{}) {
+	l.log(logging.ERROR, format, args)
+}
+
+func (l fieldLogger) Warningf(format string, args ...interface{}) {
+	l.log(logging.WARNING, format, args)
+}
+
+func (l fieldLogger) Noticef(format string, args ...interface{}) {
+	l.log(logging.NOTICE, format, args)
+}
+
+func (l fieldLogger) Infof(format string, args ...interface{}) {
+	l.log(logging.INFO, format, args)
+}
+
+func (l fieldLogger) Debugf(format string, args ...interface{}) {
+	l.log(logging.DEBUG, format, args)
+}
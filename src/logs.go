@@ -0,0 +1,112 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// logs - Build log locations
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runID identifies this run of go-build, and names its log directory. It is
+// given to the millisecond, so that runs started together don't share one
+var runID = time.Now().UTC().Format("20060102-150405.000")
+
+// logsDirectory is the name of the directory build logs are published in,
+// within the published artifacts of a branch
+const logsDirectory = "go-build-logs"
+
+// branchLogDirectory is where the script logs of a branch build are written
+func branchLogDirectory(home string, project string, branchName string) string {
+	return home + "/logs/" + runID + "/" + project + "/" + branchName
+}
+
+// scriptLogName names the logs of a script from its index and command, such as
+// "00-npm-install". The command is masked first, as the names are published
+func scriptLogName(index int, command string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(maskSecrets(command)) {
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			slug.WriteRune(r)
+			dash = false
+		case !dash && slug.Len() > 0:
+			slug.WriteByte('-')
+			dash = true
+		}
+		if slug.Len() >= 40 {
+			break
+		}
+	}
+
+	return fmt.Sprintf("%02d-%s", index, strings.TrimSuffix(slug.String(), "-"))
+}
+
+// copyLogs makes the logs in logDir available in dest. They are copied rather
+// than hardlinked, as the combined log is still being written to while the
+// artifacts are published, and the manifest must match what was published.
+func copyLogs(logDir string, dest string) (int, error) {
+	logFiles, err := filepath.Glob(logDir + "/*.log")
+	if err != nil || len(logFiles) == 0 {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return 0, err
+	}
+
+	for _, f := range logFiles {
+		if err := copyFile(f, dest+"/"+filepath.Base(f)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(logFiles), nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
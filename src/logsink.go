@@ -0,0 +1,267 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// logsink - Log output formats and the rotating log file sink
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	// defaultLogFileSize is the size at which the log file is rotated when no
+	// other size is configured
+	defaultLogFileSize = 10 * 1024 * 1024
+
+	// defaultLogFiles is the number of rotated log files kept when no other
+	// number is configured
+	defaultLogFiles = 5
+)
+
+// fileFormat is the log string formatter used for the log file, without colors
+var fileFormat = logging.MustStringFormatter(
+	`%{time:2006-01-02T15:04:05.000Z07:00} %{level:.4s} %{id:03x} %{message}`,
+)
+
+// jsonLogging is set when log records (and echoed script output) are written
+// as JSON, one object per line
+var jsonLogging bool
+
+// jsonRecord is a single JSON log record
+type jsonRecord struct {
+	Time     string  `json:"time"`
+	Level    string  `json:"level"`
+	Run      string  `json:"run"`
+	Project  string  `json:"project,omitempty"`
+	Branch   string  `json:"branch,omitempty"`
+	Phase    string  `json:"phase,omitempty"`
+	Script   *int    `json:"script,omitempty"`
+	Stream   string  `json:"stream,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+	Message  string  `json:"message"`
+}
+
+// newJSONRecord creates a JSON log record with the given fields
+func newJSONRecord(t time.Time, level string, fields logFields, message string) *jsonRecord {
+	rec := &jsonRecord{
+		Time:     t.UTC().Format(time.RFC3339Nano),
+		Level:    level,
+		Run:      runID,
+		Project:  fields.Project,
+		Branch:   fields.Branch,
+		Phase:    fields.Phase,
+		Duration: fields.Duration.Seconds(),
+		Message:  strings.TrimRight(message, "\n"),
+	}
+	if fields.Script >= 0 && fields.Phase == "script" {
+		script := fields.Script
+		rec.Script = &script
+	}
+	return rec
+}
+
+// jsonWriter writes JSON records to a writer, one per line
+type jsonWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (j *jsonWriter) write(rec *jsonRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	_, err = j.w.Write(append(line, '\n'))
+	return err
+}
+
+// consoleOut is where the console log and echoed script output are written:
+// standard output, unless the event stream is written there
+var consoleOut = os.Stdout
+
+// jsonConsole writes JSON records to the console, shared by the logger and the
+// echoed script output so their lines never interleave
+var jsonConsole = &jsonWriter{w: os.Stdout}
+
+// jsonBackend is a logging backend that writes every record as JSON, taking
+// its fields from a fieldMessage argument when there is one
+type jsonBackend struct {
+	out *jsonWriter
+}
+
+func (b *jsonBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	fields := logFields{Script: -1}
+	message := ""
+	if len(rec.Args) == 1 && rec.Args[0] != nil {
+		if msg, ok := rec.Args[0].(*fieldMessage); ok {
+			fields = msg.fields
+			message = msg.message
+		}
+	}
+	if message == "" {
+		message = rec.Message()
+	}
+
+	return b.out.write(newJSONRecord(rec.Time, level.String(), fields, message))
+}
+
+// rotatingFile is a log file that is rotated once it exceeds its maximum size,
+// keeping a number of older files as path.1 (the newest) to path.N
+type rotatingFile struct {
+	lock     sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// newRotatingFile opens (or continues) the log file at path
+func newRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = defaultLogFileSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultLogFiles
+	}
+
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// rotate closes the current file, shifts the older files along and starts a
+// new file, removing the oldest once there are more than maxFiles
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(r.path + "." + strconv.Itoa(r.maxFiles))
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		os.Rename(r.path+"."+strconv.Itoa(i), r.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// configureLogging sets up the logger from the configuration: the console in
+// text or JSON format, and the optional log file in the same format. The log
+// level in effect is kept. The console is moved to standard error when the
+// event stream is written to standard output, to keep the stream parseable.
+func configureLogging(cfg LogConfig, events EventsConfig) error {
+	switch cfg.Format {
+	case "", "text":
+		jsonLogging = false
+	case "json":
+		jsonLogging = true
+	default:
+		return errors.New("unknown log format \"" + cfg.Format + "\", expected text or json")
+	}
+
+	if events.Path == "-" {
+		consoleOut = os.Stderr
+		jsonConsole.w = consoleOut
+		consoleColor = isTerminal(consoleOut)
+	}
+
+	var backends []logging.Backend
+	if jsonLogging {
+		backends = append(backends, &jsonBackend{jsonConsole})
+	} else {
+		backends = append(backends, logging.NewBackendFormatter(logging.NewLogBackend(consoleOut, "", 0), format))
+	}
+
+	if cfg.File.Path != "" {
+		file, err := newRotatingFile(cfg.File.Path, cfg.File.MaxSize, cfg.File.MaxFiles)
+		if err != nil {
+			return err
+		}
+
+		if jsonLogging {
+			backends = append(backends, &jsonBackend{&jsonWriter{w: file}})
+		} else {
+			backends = append(backends, logging.NewBackendFormatter(logging.NewLogBackend(file, "", 0), fileFormat))
+		}
+	}
+
+	level := logging.GetLevel("")
+	var backend logging.Backend = backends[0]
+	if len(backends) > 1 {
+		backend = logging.MultiLogger(backends...)
+	}
+	logging.SetBackend(&maskingBackend{backend})
+	logging.SetLevel(level, "")
+	return nil
+}
@@ -0,0 +1,193 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// manifest - Artifact manifests and checksums
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// manifestFile is the name of the manifest written into each published directory
+	manifestFile = "manifest.json"
+
+	// checksumFile is the name of the sha256sum-compatible checksum list
+	checksumFile = "SHA256SUMS"
+)
+
+// Manifest describes the contents of a published artifacts directory, and the
+// build it came from
+type Manifest struct {
+	Project     string         `json:"project"`
+	Branch      string         `json:"branch"`
+	Commit      string         `json:"commit"`
+	Description string         `json:"description"`
+	BuildTime   string         `json:"buildTime"`
+	Version     string         `json:"version"`
+	Run         string         `json:"run"`
+	Files       []ManifestFile `json:"files"`
+}
+
+// ManifestFile is a single file entry within a Manifest, with its path relative
+// to the manifest's directory
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Mode   string `json:"mode"`
+}
+
+// manifestReserved reports whether a file is generated alongside the manifest
+// and so can't be listed within it
+func manifestReserved(name string) bool {
+	return name == manifestFile || name == checksumFile || name == manifestFile+signatureSuffix
+}
+
+// buildManifestFiles hashes every regular file beneath dir, sorted by path
+func buildManifestFiles(dir string) ([]ManifestFile, error) {
+	var files []ManifestFile
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if manifestReserved(rel) {
+			return nil
+		}
+
+		sum, err := sha256File(path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, ManifestFile{
+			Path:   rel,
+			Size:   info.Size(),
+			SHA256: sum,
+			Mode:   fmt.Sprintf("%04o", info.Mode().Perm()),
+		})
+		return nil
+	})
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	return files, err
+}
+
+// writeManifest fills in the file list of the given manifest from dir, then
+// writes it and a matching SHA256SUMS file into dir
+func writeManifest(dir string, manifest *Manifest) error {
+	files, err := buildManifestFiles(dir)
+	if err != nil {
+		return err
+	}
+	manifest.Files = files
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(dir+"/"+manifestFile, append(manifestJSON, '\n')); err != nil {
+		return err
+	}
+
+	var sums strings.Builder
+	for _, f := range files {
+		sums.WriteString(f.SHA256 + "  " + f.Path + "\n")
+	}
+
+	return writeFileAtomic(dir+"/"+checksumFile, []byte(sums.String()))
+}
+
+// manifestFilePath returns the path of a file listed in the manifest of dir,
+// which must be relative and within dir, as unsigned manifests can't be trusted
+func manifestFilePath(dir string, path string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(path))
+	if rel == "." || filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" || strings.HasPrefix(path, "/") ||
+		rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: path is outside the directory", path)
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+// readManifest loads the manifest from a published directory
+func readManifest(dir string) (*Manifest, error) {
+	raw, err := ioutil.ReadFile(dir + "/" + manifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(raw, manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func sha256File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place,
+// so a reader never sees a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,270 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// mask - Masking of secret values in logs and reports
+package main
+
+import (
+	"encoding/base64"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+)
+
+// secretPlaceholder replaces every secret value
+const secretPlaceholder = "***"
+
+// secretMasker holds the secret values registered for this run, and a replacer
+// that masks all of them, along with their common encodings
+var secretMasker = struct {
+	lock     sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}{values: make(map[string]bool)}
+
+// addSecret registers a value to be masked. Each line of a multi-line value is
+// masked separately, as output is handled line by line.
+func addSecret(value string) {
+	secretMasker.lock.Lock()
+	defer secretMasker.lock.Unlock()
+
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		variants := []string{
+			line,
+			base64.StdEncoding.EncodeToString([]byte(line)),
+			base64.RawStdEncoding.EncodeToString([]byte(line)),
+			base64.URLEncoding.EncodeToString([]byte(line)),
+			base64.RawURLEncoding.EncodeToString([]byte(line)),
+			url.QueryEscape(line),
+			url.PathEscape(line),
+		}
+		for _, v := range variants {
+			secretMasker.values[v] = true
+		}
+	}
+
+	// Longer values go first, so a secret is never partially masked by a shorter one
+	var values []string
+	for v := range secretMasker.values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+
+	var pairs []string
+	for _, v := range values {
+		pairs = append(pairs, v, secretPlaceholder)
+	}
+	secretMasker.replacer = strings.NewReplacer(pairs...)
+}
+
+// maskSecrets replaces every registered secret in s with the placeholder
+func maskSecrets(s string) string {
+	secretMasker.lock.RLock()
+	defer secretMasker.lock.RUnlock()
+
+	if secretMasker.replacer == nil {
+		return s
+	}
+	return secretMasker.replacer.Replace(s)
+}
+
+// registerConfiguredSecrets registers the values of the environment variables
+// declared as secrets, and of every project environment variable marked secret
+func registerConfiguredSecrets(config *Configuration) {
+	for _, name := range config.Secrets {
+		if value := os.Getenv(name); value != "" {
+			addSecret(value)
+		} else {
+			Log.Warningf("Secret environment variable \"%s\" is not set", name)
+		}
+	}
+
+	for _, proj := range config.Projects {
+		for _, env := range proj.Env {
+			if env.Secret {
+				addSecret(envValue(env))
+			}
+		}
+	}
+}
+
+// maskingBackend is a logging backend that masks secrets in the message of
+// every log record before passing it on. The message is masked once it is
+// formatted, so that secrets are masked whatever the types of the arguments
+// and even where they span the format and an argument
+type maskingBackend struct {
+	backend logging.Backend
+}
+
+func (b *maskingBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	// The masked record has no format, so it is logged as its only argument.
+	// Its formatter is set by the logging.NewBackendFormatter of each text
+	// backend, as the JSON backend doesn't need one
+	masked := &logging.Record{ID: rec.ID, Time: rec.Time, Module: rec.Module, Level: rec.Level}
+
+	// Records of a fieldLogger keep their fields, with the message masked
+	if len(rec.Args) == 1 {
+		if msg, ok := rec.Args[0].(*fieldMessage); ok {
+			masked.Args = []interface{}{&fieldMessage{msg.fields, maskSecrets(msg.message)}}
+			return b.backend.Log(level, calldepth+1, masked)
+		}
+	}
+
+	masked.Args = []interface{}{maskSecrets(rec.Message())}
+	return b.backend.Log(level, calldepth+1, masked)
+}
+
+// maskedStrings returns s when it contains no secrets, otherwise a pointer to
+// a masked copy, so that plugins never see secret values
+func maskedStrings(s *[]string) *[]string {
+	masked := make([]string, len(*s))
+	changed := false
+	for i, v := range *s {
+		masked[i] = maskSecrets(v)
+		changed = changed || masked[i] != v
+	}
+	if !changed {
+		return s
+	}
+	return &masked
+}
+
+// maskedProject returns a copy of a project configuration for plugins, with
+// the secrets in all of its values masked
+func maskedProject(proj *ProjectConfig) *ProjectConfig {
+	masked := maskValue(reflect.ValueOf(*proj)).Interface().(ProjectConfig)
+	return &masked
+}
+
+// unmaskProject takes back the changes plugins made to the masked copy of a
+// project configuration that was sent to them
+func unmaskProject(proj *ProjectConfig, sent *ProjectConfig, changed *ProjectConfig) {
+	*proj = unmaskValue(reflect.ValueOf(*proj), reflect.ValueOf(*sent), reflect.ValueOf(*changed)).Interface().(ProjectConfig)
+}
+
+// unmaskStrings is unmaskProject for a slice of strings sent masked
+func unmaskStrings(s []string, sent []string, changed []string) []string {
+	return unmaskValue(reflect.ValueOf(s), reflect.ValueOf(sent), reflect.ValueOf(changed)).Interface().([]string)
+}
+
+// maskValue returns a deep copy of a configuration value, with the secrets in
+// all of its strings masked
+func maskValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(maskSecrets(v.String())).Convert(v.Type())
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return reflect.ValueOf([]byte(maskSecrets(string(v.Bytes())))).Convert(v.Type())
+		}
+		masked := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			masked.Index(i).Set(maskValue(v.Index(i)))
+		}
+		return masked
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		masked := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			masked.SetMapIndex(iter.Key(), maskValue(iter.Value()))
+		}
+		return masked
+	case reflect.Struct:
+		masked := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			masked.Field(i).Set(maskValue(v.Field(i)))
+		}
+		return masked
+	}
+	return v
+}
+
+// unmaskValue returns a value as changed by plugins from the masked copy sent
+// to them, where anything left as it was sent is taken from the original, so
+// that masked secrets are only replaced if a plugin changed them. Elements of
+// slices are matched by position or else by value, as plugins may add or
+// remove them
+func unmaskValue(orig reflect.Value, sent reflect.Value, changed reflect.Value) reflect.Value {
+	if reflect.DeepEqual(sent.Interface(), changed.Interface()) {
+		return orig
+	}
+
+	switch changed.Kind() {
+	case reflect.Slice:
+		if changed.IsNil() || changed.Type().Elem().Kind() == reflect.Uint8 {
+			return changed
+		}
+		merged := reflect.MakeSlice(changed.Type(), changed.Len(), changed.Len())
+		for i := 0; i < changed.Len(); i++ {
+			merged.Index(i).Set(unmaskElement(orig, sent, changed.Index(i), i))
+		}
+		return merged
+	case reflect.Struct:
+		merged := reflect.New(changed.Type()).Elem()
+		for i := 0; i < changed.NumField(); i++ {
+			merged.Field(i).Set(unmaskValue(orig.Field(i), sent.Field(i), changed.Field(i)))
+		}
+		return merged
+	}
+	return changed
+}
+
+// unmaskElement returns element i of a slice as changed by plugins: the
+// original of an element sent at the same position or with the same value
+func unmaskElement(orig reflect.Value, sent reflect.Value, elem reflect.Value, i int) reflect.Value {
+	if i < sent.Len() && reflect.DeepEqual(sent.Index(i).Interface(), elem.Interface()) {
+		return orig.Index(i)
+	}
+	for j := 0; j < sent.Len(); j++ {
+		if reflect.DeepEqual(sent.Index(j).Interface(), elem.Interface()) {
+			return orig.Index(j)
+		}
+	}
+	if i < sent.Len() {
+		return unmaskValue(orig.Index(i), sent.Index(i), elem)
+	}
+	return elem
+}
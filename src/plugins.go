@@ -0,0 +1,240 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// plugins - Per-project plugin activation and lazy loading
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Danw33/go-build/pluginapi"
+)
+
+// pluginsMu guards buildPlugins and buildPluginNames, which plugins listed only
+// by projects are appended to while projects are processed
+var pluginsMu sync.RWMutex
+
+// globalPlugins is the number of buildPlugins listed in the global configuration,
+// which come first
+var globalPlugins int
+
+// pluginConfig is the raw configuration passed to each plugin on initialisation
+var pluginConfig []byte
+
+// lazyMu serialises loading the plugins listed only by projects
+var lazyMu sync.Mutex
+
+// failedPlugins contains the plugin files that could not be loaded, so they
+// aren't tried again for every project listing them
+var failedPlugins = map[string]bool{}
+
+// projectPlugins contains the plugins active for each project being processed
+var projectPlugins = map[*ProjectConfig]*activeSet{}
+
+// activeSet is the set of plugins active for a project, and the config section
+// of each from the plugin list of the project
+type activeSet struct {
+	plugins []int
+	config  map[int][]byte
+}
+
+// pluginAt returns the plugin at index i of buildPlugins, and its name
+func pluginAt(i int) (pluginapi.Plugin, string) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	return buildPlugins[i], buildPluginNames[i]
+}
+
+// pluginLock returns the lock serializing the hooks of plugin i, or nil if it
+// is concurrent-safe
+func pluginLock(i int) *sync.Mutex {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	return buildPluginLocks[i]
+}
+
+// allPlugins returns the index of every loaded plugin, which run-level hooks
+// are dispatched to
+func allPlugins() []int {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	plugins := make([]int, len(buildPlugins))
+	for i := range plugins {
+		plugins[i] = i
+	}
+	return plugins
+}
+
+// pluginName returns the name of a plugin file, without its directory and extension
+func pluginName(file string) string {
+	base := filepath.Base(file)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// resolvePluginEntries gives the entries of the global and project plugin lists
+// that name a plugin compiled into the binary, without a path, its name as
+// their path, as the name is used in place of a path for these plugins
+func resolvePluginEntries(config *Configuration) {
+	resolve := func(entries []PluginConfig) {
+		for i, entry := range entries {
+			if _, ok := pluginapi.Registered(entry.Name); ok && entry.Path == "" {
+				entries[i].Path = entry.Name
+			}
+		}
+	}
+
+	resolve(config.Plugins)
+	for _, proj := range config.Projects {
+		resolve(proj.Plugins)
+	}
+}
+
+// pluginEntryMatches returns whether an entry of a project plugin list refers
+// to the given plugin: by its name if the entry has one, otherwise by the path
+// of its file or the name that path gives
+func pluginEntryMatches(entry PluginConfig, name string, file string) bool {
+	if entry.Name != "" {
+		return entry.Name == name
+	}
+	return entry.Path == file || pluginName(entry.Path) == name
+}
+
+// activatePlugins resolves the plugins active for a project, which its project,
+// branch and artifact hooks are dispatched to: every global plugin it doesn't
+// disable, then the plugins it lists that aren't global, loaded on first use
+func activatePlugins(proj *ProjectConfig) {
+	plog := projectLog(proj.Path).withPhase("plugin")
+	set := &activeSet{config: map[int][]byte{}}
+
+	pluginsMu.RLock()
+	names, files := buildPluginNames[:globalPlugins], buildPluginFiles[:globalPlugins]
+	pluginsMu.RUnlock()
+
+	global := make([]bool, len(proj.Plugins))
+	for i, name := range names {
+		active := true
+		for e, entry := range proj.Plugins {
+			if !pluginEntryMatches(entry, name, files[i]) {
+				continue
+			}
+			global[e] = true
+			active = !entry.Disabled
+			set.config[i] = maskedConfig(entry.Config)
+		}
+
+		if !active {
+			plog.Debugf("plugin \"%s\" is disabled for this project\n", name)
+			continue
+		}
+		set.plugins = append(set.plugins, i)
+	}
+
+	for e, entry := range proj.Plugins {
+		if global[e] || entry.Disabled {
+			continue
+		}
+
+		if entry.Path == "" {
+			plog.Errorf("plugin \"%s\" is not loaded globally, and no path is given to load it from\n", entry.Name)
+			continue
+		}
+
+		if i, ok := loadProjectPlugin(entry, plog); ok {
+			set.plugins = append(set.plugins, i)
+			set.config[i] = maskedConfig(entry.Config)
+		}
+	}
+
+	pluginsMu.Lock()
+	projectPlugins[proj] = set
+	pluginsMu.Unlock()
+}
+
+// releasePlugins forgets the plugins active for a project once it is processed
+func releasePlugins(proj *ProjectConfig) {
+	pluginsMu.Lock()
+	delete(projectPlugins, proj)
+	pluginsMu.Unlock()
+}
+
+// activePlugins returns the plugins active for a project being processed
+func activePlugins(proj *ProjectConfig) []int {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	if set, ok := projectPlugins[proj]; ok {
+		return set.plugins
+	}
+	return nil
+}
+
+// projectPluginConfig returns the config section of plugin i from the plugin
+// list of a project being processed
+func projectPluginConfig(proj *ProjectConfig, i int) []byte {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	if set, ok := projectPlugins[proj]; ok {
+		return set.config[i]
+	}
+	return nil
+}
+
+// loadProjectPlugin returns the index of a plugin listed only by projects,
+// loading it the first time it is used, without a global config section. A
+// plugin loaded late still receives the run-level hooks it missed, with a copy
+// of the run context
+func loadProjectPlugin(entry PluginConfig, plog fieldLogger) (int, bool) {
+	file := entry.Path
+	lazyMu.Lock()
+	defer lazyMu.Unlock()
+
+	pluginsMu.RLock()
+	for i := globalPlugins; i < len(buildPluginFiles); i++ {
+		if buildPluginFiles[i] == file {
+			pluginsMu.RUnlock()
+			return i, true
+		}
+	}
+	pluginsMu.RUnlock()
+
+	if failedPlugins[file] {
+		return -1, false
+	}
+
+	plog.Infof("loading plugin \"%s\" for this project\n", file)
+	pluginsMu.Lock()
+	i, ok := loadPlugin(entry, nil, plog)
+	pluginsMu.Unlock()
+	if !ok {
+		failedPlugins[file] = true
+		return -1, false
+	}
+
+	run := *runContext
+	runHookFailed(runPostLoadPlugins(runSpan, []int{i}, &run))
+	runHookFailed(runPreProcessProjects(runSpan, []int{i}, &run))
+	return i, true
+}
@@ -0,0 +1,115 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// pluginv1 - Adapter running version 1 plugins through the version 2 interface
+package main
+
+import (
+	"context"
+
+	"github.com/Danw33/go-build/pluginapi"
+)
+
+// v1Plugin adapts a BuildPlugin to the version 2 plugin interface. Version 1
+// hooks are passed pointers into the hook context, so changes made by the
+// plugin are seen by the core as they were before. The contexts are those of
+// projectView, so the secrets in them are masked
+type v1Plugin struct {
+	plugin BuildPlugin
+
+	// config is the whole (masked) configuration file, which version 1 plugins
+	// are initialised with instead of their own section
+	config []byte
+}
+
+func (p v1Plugin) PluginInit(ctx context.Context, config []byte) error {
+	return p.plugin.PluginInit(p.config)
+}
+
+// ConcurrentSafe is true if the version 1 plugin declares it is, with the same
+// method as version 2 plugins
+func (p v1Plugin) ConcurrentSafe() bool {
+	if cs, ok := p.plugin.(pluginapi.ConcurrentSafe); ok {
+		return cs.ConcurrentSafe()
+	}
+	return false
+}
+
+func (p v1Plugin) PostLoadPlugins(run *pluginapi.RunContext) error {
+	version, buildTime := run.Version, run.BuildTime
+	p.plugin.PostLoadPlugins(&version, &buildTime)
+	return nil
+}
+
+func (p v1Plugin) PreProcessProjects(run *pluginapi.RunContext) error {
+	p.plugin.PreProcessProjects(&run.WorkingDir, &run.HomeDir, &run.Async)
+	return nil
+}
+
+func (p v1Plugin) PostProcessProjects(run *pluginapi.RunContext, result *pluginapi.Result) error {
+	p.plugin.PostProcessProjects(&run.WorkingDir, &run.HomeDir, &run.Async)
+	return nil
+}
+
+func (p v1Plugin) PreProcessProject(pc *pluginapi.ProjectContext) error {
+	proj := pc.Project
+	p.plugin.PreProcessProject(&proj.URL, &proj.Path, &proj.Artifacts, &proj.Branches, &proj.Scripts)
+	return nil
+}
+
+// PostProcessProject is only called for version 1 plugins when the project succeeded
+func (p v1Plugin) PostProcessProject(pc *pluginapi.ProjectContext, result *pluginapi.Result) error {
+	if result.Status != pluginapi.StatusSucceeded {
+		return nil
+	}
+	proj := pc.Project
+	p.plugin.PostProcessProject(&proj.URL, &proj.Path, &proj.Artifacts, &proj.Branches, &proj.Scripts)
+	return nil
+}
+
+func (p v1Plugin) PreProcessBranch(bc *pluginapi.BranchContext) error {
+	p.plugin.PreProcessBranch(&bc.Dir, &bc.Name, &bc.Description)
+	return nil
+}
+
+// PostProcessBranch is only called for version 1 plugins when the branch
+// succeeded and its artifacts were published
+func (p v1Plugin) PostProcessBranch(bc *pluginapi.BranchContext, result *pluginapi.Result) error {
+	if result.Status != pluginapi.StatusSucceeded || !bc.Published {
+		return nil
+	}
+	p.plugin.PostProcessBranch(&bc.Dir, &bc.Name, &bc.Description)
+	return nil
+}
+
+func (p v1Plugin) PreProcessArtifacts(ac *pluginapi.ArtifactsContext) error {
+	p.plugin.PreProcessArtifacts(&ac.Path, &ac.Project.Path, &ac.Name)
+	return nil
+}
+
+func (p v1Plugin) PostProcessArtifacts(ac *pluginapi.ArtifactsContext, result *pluginapi.Result) error {
+	p.plugin.PostProcessArtifacts(&ac.Path, &ac.Project.Path, &ac.Name)
+	return nil
+}
@@ -27,26 +27,34 @@ SOFTWARE.
 package main
 
 import (
-	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
 
+	"github.com/Danw33/go-build/pluginapi"
 	"github.com/libgit2/git2go"
-	"github.com/getsentry/raven-go"
-	"strconv"
-	"path/filepath"
 )
 
 type scriptVariables struct {
-	Project   string
-	Branch    string
-	URL       string
-	Artifacts string
+	Project      string
+	Branch       string
+	URL          string
+	Artifacts    string
+	PublicURL    string
+	ArtifactsURL string
+	Secrets      map[string]string
+}
+
+// branchVariables returns the variables that can be substituted in the
+// scripts and artifacts path of a project for a branch
+func branchVariables(proj ProjectConfig, branchName string) scriptVariables {
+	return scriptVariables{proj.Path, branchName, proj.URL, proj.Artifacts, publicBaseURL, publicURL(artifactsKey(proj.Path, branchName)), buildSecrets.forProject(proj.Path)}
 }
 
 var pwd string
@@ -67,30 +75,15 @@ func processProjects(config *Configuration, cloneOpts *git.CloneOptions) {
 		if config.Async == true {
 			// Async enabled, use goroutines :-
 			go func(config *Configuration, proj ProjectConfig, cloneOpts *git.CloneOptions) {
-				defer func() {
-					if r := recover(); r != nil {
-						if _, ok := r.(runtime.Error); ok {
-							Log.Critical("Processing Project", proj.Path, "caused a runtime error:", r)
-							panic(r)
-						}
-						Log.Error("Processing Project", proj.Path, "failed:", r)
-					} else {
-						Log.Info("Processing Project", proj.Path, "completed")
-					}
-				}()
 				defer w.Done()
 				Log.Infof("Processing project \"%s\" from url: \"%s\" in asynchronous mode.\n", proj.Path, proj.URL)
-				runPreProcessProject(&proj.URL, &proj.Path, &proj.Artifacts, &proj.Branches, &proj.Scripts)
-				processRepo(config, proj, cloneOpts)
-				runPostProcessProject(&proj.URL, &proj.Path, &proj.Artifacts, &proj.Branches, &proj.Scripts)
+				processProject(config, proj, cloneOpts)
 			}(config, proj, cloneOpts)
 		} else {
 			// Async disabled, run normally in loop :-(
 			Log.Debug("Asynchronous Mode Disabled: Projects will be built in sequence.")
 			Log.Infof("Processing project \"%s\" from url: \"%s\".\n", proj.Path, proj.URL)
-			runPreProcessProject(&proj.URL, &proj.Path, &proj.Artifacts, &proj.Branches, &proj.Scripts)
-			processRepo(config, proj, cloneOpts)
-			runPostProcessProject(&proj.URL, &proj.Path, &proj.Artifacts, &proj.Branches, &proj.Scripts)
+			processProject(config, proj, cloneOpts)
 		}
 	}
 
@@ -101,23 +94,109 @@ func processProjects(config *Configuration, cloneOpts *git.CloneOptions) {
 	Log.Info("Finished processing all configured projects.")
 }
 
-func processRepo(config *Configuration, proj ProjectConfig, cloneOpts *git.CloneOptions) {
+// processProject processes a project between its plugin hooks. A failed project
+// is logged and passed to the PostProcessProject hooks, and the run carries on
+func processProject(config *Configuration, proj ProjectConfig, cloneOpts *git.CloneOptions) {
+	plog := projectLog(proj.Path)
+
+	if runAborted() {
+		plog.Warningf("the run was aborted, project will not be processed")
+		return
+	}
+
+	pStart := time.Now()
+	pspan := startProjectSpan(proj)
+	pc := &pluginapi.ProjectContext{RunContext: runContext, Project: &proj, Dir: config.Home + "/projects/" + proj.Path}
+	ptimer := newStepTimer(proj.Path, "")
+
+	// skipped is the decision of a hook to skip the project
+	var skipped error
+
+	defer func() {
+		r := recover()
+		var err error
+		if r != nil {
+			err = fmt.Errorf("%v", r)
+			if _, ok := r.(runtime.Error); ok {
+				pspan.End(err)
+				plog.Criticalf("processing caused a runtime error: %v", r)
+				panic(r)
+			}
+			plog.Errorf("processing failed: %v", r)
+			emitEvent("projectFailed", proj.Path, "", map[string]interface{}{"error": fmt.Sprint(r)})
+		}
+
+		result := hookResult(err, time.Since(pStart))
+		if err == nil && skipped != nil {
+			result = hookResult(skipped, time.Since(pStart))
+			plog.Infof("processing skipped: %v", skipped)
+			emitEvent("projectSkipped", proj.Path, "", map[string]interface{}{"reason": skipped.Error()})
+		}
+
+		hspan := startStep(pspan, ptimer, "hook PostProcessProject")
+		hErr := runPostProcessProject(hspan, pc, result)
+		hspan.End(hErr)
+		if hookFailed(hErr) && err == nil {
+			err = hErr
+			result = hookResult(err, time.Since(pStart))
+			plog.Errorf("processing failed: %v", err)
+			emitEvent("projectFailed", proj.Path, "", map[string]interface{}{"error": err.Error()})
+		}
+		releasePlugins(&proj)
+		recordResult(proj.Path, "", result, "")
+		if err == nil && skipped == nil {
+			emitEvent("postProcessProject", proj.Path, "", projectEventData(proj))
+			plog.Infof("processing completed")
+		}
+		pspan.End(err)
+	}()
+
+	activatePlugins(&proj)
+	hspan := startStep(pspan, ptimer, "hook ConfigureProject")
+	hookErr := runConfigureProject(hspan, pc)
+	hspan.End(hookErr)
+	if hookFailed(hookErr) {
+		panic(hookErr)
+	} else if hookSkipped(hookErr) {
+		skipped = hookErr
+		return
+	}
+
+	// Pre-processing hooks may change the project configuration, or skip it
+	hspan = startStep(pspan, ptimer, "hook PreProcessProject")
+	hookErr = runPreProcessProject(hspan, pc)
+	hspan.End(hookErr)
+	if hookFailed(hookErr) {
+		panic(hookErr)
+	} else if hookSkipped(hookErr) {
+		skipped = hookErr
+		return
+	}
+	emitEvent("preProcessProject", proj.Path, "", projectEventData(proj))
+
+	processRepo(config, proj, cloneOpts, pc, pspan, ptimer)
+}
+
+func processRepo(config *Configuration, proj ProjectConfig, cloneOpts *git.CloneOptions, pc *pluginapi.ProjectContext, pspan *span, ptimer *stepTimer) {
 	var repo *git.Repository
 	var twd string
 	fresh := false
 
 	pStart := time.Now()
+	plog := projectLog(proj.Path).withPhase("clone")
 
-	Log.Debugf(" [%s] - checking for existing clone...\n", proj.Path)
+	plog.Debugf("checking for existing clone...\n")
 
 	// Target working directory for this repo
 	twd = config.Home + "/projects/" + proj.Path
 
 	if _, err := os.Stat(twd); os.IsNotExist(err) {
-		Log.Infof(" [%s] - project at \"%s\" does not exist, creating clone...\n", proj.Path, twd)
+		plog.Infof("project at \"%s\" does not exist, creating clone...\n", twd)
+		cspan := startStep(pspan, ptimer, "clone")
 		repo, err = cloneRepo(twd, proj.URL, proj.Path, cloneOpts)
+		cspan.End(err)
 		if err != nil {
-			raven.CaptureErrorAndWait(err, nil)
+			reportErrorAndWait(err)
 			Log.Critical(err)
 			panic(err)
 		}
@@ -125,69 +204,76 @@ func processRepo(config *Configuration, proj ProjectConfig, cloneOpts *git.Clone
 	}
 
 	if _, err := os.Stat(twd); err == nil {
-		Log.Infof(" [%s] - opening repository in \"%s\"...\n", proj.Path, twd)
+		plog.Infof("opening repository in \"%s\"...\n", twd)
 		repo, err = git.OpenRepository(twd)
 		if err != nil {
-			raven.CaptureErrorAndWait(err, nil)
+			reportErrorAndWait(err)
 			Log.Critical(err)
 			panic(err)
 		}
 	} else {
-		Log.Debugf(" [%s] - error opening repository in \"%s\"\n", proj.Path, twd)
-		raven.CaptureErrorAndWait(err, nil)
+		plog.Debugf("error opening repository in \"%s\"\n", twd)
+		reportErrorAndWait(err)
 		Log.Critical(err)
 		panic(err)
 	}
 
-	Log.Debugf(" [%s] - loading repository configuration...\n", proj.Path)
+	plog.Debugf("loading repository configuration...\n")
 
 	repoConfig, err := repo.Config()
 	if err != nil {
-		raven.CaptureErrorAndWait(err, nil)
+		reportErrorAndWait(err)
 		Log.Critical(err)
 		panic(err)
 	}
 	defer repoConfig.Free()
 
-	Log.Debugf(" [%s] - enabling remote origin pruning...\n", proj.Path)
+	plog.Debugf("enabling remote origin pruning...\n")
 	repoConfig.SetBool("remote.origin.prune", true)
 
-	Log.Debugf(" [%s] - testing repository type (isBare)...\n", proj.Path)
+	plog.Debugf("testing repository type (isBare)...\n")
 	if repo.IsBare() {
-		Log.Debugf(" [%s] - bare repository loaded and configured\n", proj.Path)
+		plog.Debugf("bare repository loaded and configured\n")
 	} else {
-		Log.Debugf(" [%s] - repository loaded and configured\n", proj.Path)
+		plog.Debugf("repository loaded and configured\n")
 	}
 
+	plog = plog.withPhase("fetch")
+
 	if fresh != true {
 		// This isn't a fresh clone, but an existing repo. Fetch changes...
-		Log.Debugf(" [%s] - fetching changes from remote...\n", proj.Path)
+		plog.Debugf("fetching changes from remote...\n")
+		fspan := startStep(pspan, ptimer, "fetch")
 		err = fetchChanges(repo, proj.URL, proj.Path)
+		fspan.End(err)
 		if err != nil {
-			raven.CaptureError(err, nil)
-			Log.Errorf(" [%s] - failed to fetch changes from remote:\n", proj.Path)
+			reportError(err)
+			plog.Errorf("failed to fetch changes from remote:\n")
 			Log.Critical(err)
 		}
 
-		Log.Debugf(" [%s] - pulling changes from remote...\n", proj.Path)
+		plog.Debugf("pulling changes from remote...\n")
+		pullSpan := startStep(pspan, ptimer, "pull")
 		err = pullChanges(repo, proj.Path)
+		pullSpan.End(err)
 		if err != nil {
-			raven.CaptureError(err, nil)
-			Log.Errorf(" [%s] - failed to pull changes from remote:\n", proj.Path)
+			reportError(err)
+			plog.Errorf("failed to pull changes from remote:\n")
 			Log.Critical(err)
 		}
 	}
 
-	Log.Debugf(" [%s] - loading object database\n", proj.Path)
+	plog.Debugf("loading object database\n")
+	ospan := startStep(pspan, ptimer, "count objects")
 
 	odb, err := repo.Odb()
 	if err != nil {
-		raven.CaptureErrorAndWait(err, nil)
+		reportErrorAndWait(err)
 		Log.Critical(err)
 		panic(err)
 	}
 
-	Log.Debugf(" [%s] - counting objects\n", proj.Path)
+	plog.Debugf("counting objects\n")
 
 	odblen := 0
 	err = odb.ForEach(func(oid *git.Oid) error {
@@ -195,254 +281,614 @@ func processRepo(config *Configuration, proj ProjectConfig, cloneOpts *git.Clone
 		return nil
 	})
 	if err != nil {
-		raven.CaptureErrorAndWait(err, nil)
+		reportErrorAndWait(err)
 		Log.Critical(err)
 		panic(err)
 	}
 
-	Log.Debugf(" [%s] - object database loaded, %d objects.\n", proj.Path, odblen)
+	plog.Debugf("object database loaded, %d objects.\n", odblen)
+	ospan.SetAttr("objects", odblen)
+	ospan.End(nil)
+	plog = plog.withPhase("")
 
-	Log.Debugf(" [%s] - loading branch processing configuration...\n", proj.Path)
+	plog.Debugf("loading branch processing configuration...\n")
 	if proj.Branches[0] == "*" {
-		Log.Debugf(" [%s] - project is configured to have all branches built.\n", proj.Path)
+		plog.Debugf("project is configured to have all branches built.\n")
 		proj.Branches = []string{"master", "develop"}
-		Log.Warningf(" [%s] - project is set for wildcard branch build, but it is not yet supported; Only master and develop will be built.\n", proj.Path)
+		plog.Warningf("project is set for wildcard branch build, but it is not yet supported; Only master and develop will be built.\n")
 	} else {
-		Log.Debugf(" [%s] - project is configured to have the following branches built: %s\n", proj.Path, strings.Join(proj.Branches[:], ", "))
+		plog.Debugf("project is configured to have the following branches built: %s\n", strings.Join(proj.Branches[:], ", "))
 	}
 
-
 	processedBranches := 0
 
 	for _, branchName := range proj.Branches {
+		if runAborted() {
+			plog.Warningf("the run was aborted, remaining branches will not be processed\n")
+			break
+		}
+
 		processedBranches++
-		Log.Infof(" [%s] - processing branch %d \"%s\"...\n", proj.Path, processedBranches, branchName)
+		plog.withBranch(branchName).Infof("processing branch %d \"%s\"...\n", processedBranches, branchName)
 		bStart := time.Now()
-		processBranch(config, proj, twd, branchName, repo)
-		Log.Infof(" [%s] - completed branch %d \"%s\" in: %s\n", proj.Path, processedBranches, branchName, time.Since(bStart))
+		processBranch(config, proj, twd, branchName, repo, pc, pspan)
+		plog.withBranch(branchName).withDuration(time.Since(bStart)).Infof("completed branch %d \"%s\" in: %s\n", processedBranches, branchName, time.Since(bStart))
 	}
 
-	Log.Infof(" [%s] - completed %d branches in: %s\n", proj.Path, processedBranches, time.Since(pStart))
+	plog.withDuration(time.Since(pStart)).Infof("completed %d branches in: %s\n", processedBranches, time.Since(pStart))
+	plog.Infof("project step timings: %s\n", ptimer.breakdown())
 }
 
-func processBranch(config *Configuration, proj ProjectConfig, twd string, branchName string, repo *git.Repository) {
+func processBranch(config *Configuration, proj ProjectConfig, twd string, branchName string, repo *git.Repository, pc *pluginapi.ProjectContext, pspan *span) {
+	plog := projectLog(proj.Path).withBranch(branchName)
+	bStart := time.Now()
+	bc := &pluginapi.BranchContext{ProjectContext: pc, Name: branchName}
 
-	Log.Debugf(" [%s] - running project scripts...\n", proj.Path)
+	plog.Debugf("running project scripts...\n")
+
+	var blog *combinedLog
+	btimer := newStepTimer(proj.Path, branchName)
+	bspan := startSpan(pspan, "branch "+branchName)
+	bspan.SetAttr("branch", branchName)
+
+	// skipped is the decision of a hook to skip the branch, and artifactsStatus
+	// what became of its artifacts
+	var skipped error
+	artifactsStatus := ""
 
 	defer func() {
-		if r := recover(); r != nil {
+		r := recover()
+		blog.Finish(r)
+		var err error
+		if r != nil {
+			err = fmt.Errorf("%v", r)
 			if _, ok := r.(runtime.Error); ok {
-				Log.Critical("Processing project", proj.Path, "branch", branchName, "caused a runtime error:", r)
+				bspan.End(err)
+				plog.Criticalf("processing caused a runtime error: %v", r)
 				panic(r)
 			}
-			Log.Error("Processing project", proj.Path, "branch", branchName, "failed:", r)
-		} else {
-			Log.Info("Processing project", proj.Path, "branch", branchName, "completed.")
+			plog.Errorf("processing failed: %v", r)
+			emitEvent("branchFailed", proj.Path, branchName, map[string]interface{}{"error": fmt.Sprint(r)})
 		}
+
+		result := hookResult(err, time.Since(bStart))
+		if err == nil && skipped != nil {
+			result = hookResult(skipped, time.Since(bStart))
+			plog.Infof("processing skipped: %v", skipped)
+			emitEvent("branchSkipped", proj.Path, branchName, map[string]interface{}{"commit": bc.Commit, "reason": skipped.Error()})
+		}
+
+		hspan := startStep(bspan, btimer, "hook PostProcessBranch")
+		hErr := runPostProcessBranch(hspan, bc, result)
+		hspan.End(hErr)
+		if hookFailed(hErr) && err == nil {
+			// A failed post-processing hook fails the branch, though its work is done
+			err = hErr
+			result = hookResult(err, time.Since(bStart))
+			plog.Errorf("processing failed: %v", err)
+			emitEvent("branchFailed", proj.Path, branchName, map[string]interface{}{"error": err.Error()})
+		}
+		recordResult(proj.Path, branchName, result, artifactsStatus)
+		if err == nil && skipped == nil {
+			emitEvent("postProcessBranch", proj.Path, branchName, map[string]interface{}{"directory": twd, "commit": bc.Commit, "description": bc.Description})
+			plog.Infof("processing completed.")
+		}
+
+		plog.Infof("step timings: %s\n", btimer.breakdown())
+		bspan.End(err)
 	}()
 
+	// Logs are kept outside the working tree, so they survive a failed build and the next checkout
+	logDir := branchLogDirectory(config.Home, proj.Path, branchName)
+	plog.Debugf("build logs will be written to \"%s\"\n", logDir)
+	ldErr := os.MkdirAll(logDir, 0755)
+	if ldErr != nil {
+		reportErrorAndWait(ldErr)
+		Log.Critical(ldErr)
+		panic(ldErr)
+	}
 
-	Log.Debugf(" [%s] - checking out branch \"%s\"...\n", proj.Path, branchName)
+	blog, ldErr = newCombinedLog(logDir + "/" + combinedLogFile)
+	if ldErr != nil {
+		reportErrorAndWait(ldErr)
+		Log.Critical(ldErr)
+		panic(ldErr)
+	}
+
+	plog = plog.withPhase("checkout")
+	plog.Debugf("checking out branch \"%s\"...\n", branchName)
+	blog.Begin("checkout " + branchName)
+	cospan := startStep(bspan, btimer, "checkout")
 	coErr := checkoutBranch(repo, branchName)
+	cospan.End(coErr)
+	blog.End(stepStatus(coErr))
 	if coErr != nil {
-		raven.CaptureErrorAndWait(coErr, nil)
-		Log.Errorf(" [%s] - failed to checkout branch %s:\n", proj.Path, branchName)
+		reportErrorAndWait(coErr)
+		plog.Errorf("failed to checkout branch %s:\n", branchName)
 		Log.Critical(coErr)
 		panic(coErr)
 	}
 
-	Log.Infof(" [%s] - pulling changes from remote for branch %s...\n", proj.Path, branchName)
+	plog = plog.withPhase("pull")
+	plog.Infof("pulling changes from remote for branch %s...\n", branchName)
+	blog.Begin("sync")
+	pullSpan := startStep(bspan, btimer, "pull")
 	pullErr := pullChanges(repo, proj.Path)
+	pullSpan.End(pullErr)
+	blog.End(stepStatus(pullErr))
 	if pullErr != nil {
-		raven.CaptureError(pullErr, nil)
-		Log.Errorf(" [%s] - failed to pull changes from remote for branch %s:\n", proj.Path, branchName)
+		reportError(pullErr)
+		plog.Errorf("failed to pull changes from remote for branch %s:\n", branchName)
 		Log.Critical(pullErr)
 	}
+	plog = plog.withPhase("checkout")
 
 	description, descErr := describeWorkDir(repo, proj.Path)
 	if descErr != nil {
-		Log.Errorf(" [%s] - failed to describe working directory state post-checkout for branch %s:\n", proj.Path, branchName)
+		plog.Errorf("failed to describe working directory state post-checkout for branch %s:\n", branchName)
 		Log.Error(descErr)
 	}
 	if description != "" {
-		Log.Infof(" [%s] - on branch \"%s\", working directory is %s\n", proj.Path, branchName, description)
+		plog.Infof("on branch \"%s\", working directory is %s\n", branchName, description)
+	}
+
+	commitID, commitErr := headCommitID(repo, proj.Path)
+	if commitErr != nil {
+		plog.Errorf("failed to find the commit checked out for branch %s:\n", branchName)
+		Log.Error(commitErr)
+	}
+
+	bspan.SetAttr("commit", commitID)
+	bspan.SetAttr("description", description)
+	bc.Commit, bc.Description = commitID, description
+
+	hspan := startStep(bspan, btimer, "hook PreProcessBranch")
+	hookErr := runPreProcessBranch(hspan, bc)
+	hspan.End(hookErr)
+	if hookFailed(hookErr) {
+		panic(hookErr)
+	} else if hookSkipped(hookErr) {
+		skipped = hookErr
+		return
+	}
+	emitEvent("preProcessBranch", proj.Path, branchName, map[string]interface{}{"directory": twd, "commit": commitID, "description": description})
+
+	// Plugins give the values of their template variables once the branch is checked out
+	hspan = startStep(bspan, btimer, "hook "+templateValuesHook)
+	tmplValues, hookErr := runTemplateValues(hspan, bc)
+	hspan.End(hookErr)
+	if hookFailed(hookErr) {
+		panic(hookErr)
 	}
 
-	runPreProcessBranch(&twd, &branchName, &description)
+	artifactsPath, tmplErr := expandTemplate("artifacts", proj.Artifacts, templateData(tmplValues, branchVariables(proj, branchName)))
+	if tmplErr != nil {
+		reportErrorAndWait(tmplErr)
+		plog.Errorf("failed to expand the artifacts path \"%s\":\n", proj.Artifacts)
+		Log.Critical(tmplErr)
+		panic(tmplErr)
+	}
+	proj.Artifacts = artifactsPath
 
-	runProjectScripts(twd, branchName, proj)
+	runProjectScripts(config, twd, logDir, blog, bc, proj, tmplValues, bspan, btimer)
 
-	Log.Debugf(" [%s] - configuring artifacts pick-up path...\n", proj.Path)
+	plog = plog.withPhase("artifacts")
+	plog.Debugf("configuring artifacts pick-up path...\n")
 	artifacts := twd + "/" + proj.Artifacts
 
 	if _, afErr := os.Stat(artifacts); os.IsNotExist(afErr) {
-		Log.Warningf(" [%s] ! build artifacts could not be found, maybe the build failed?\n", proj.Path)
-		Log.Infof(" [%s] ! expected build artifacts in: \"%s\"\n", proj.Path, artifacts)
-		Log.Noticef(" [%s] ! no build will be published for this project/branch.\n", proj.Path)
+		plog.Warningf("build artifacts could not be found, maybe the build failed?\n")
+		plog.Infof("expected build artifacts in: \"%s\"\n", artifacts)
+		plog.Noticef("no build will be published for this project/branch.\n")
+		plog.Noticef("build logs are available in: \"%s\"\n", logDir)
+		emitEvent("artifactsMissing", proj.Path, branchName, map[string]interface{}{"path": artifacts, "logs": logDir})
+		artifactsStatus = "missing"
 		return
 	}
 
 	if _, err := os.Stat(artifacts); err == nil {
-		Log.Debugf(" [%s] - build artifacts found in: \"%s\"...\n", proj.Path, artifacts)
+		plog.Debugf("build artifacts found in: \"%s\"...\n", artifacts)
 	}
 
-	Log.Debugf(" [%s] - processing artifacts from pick-up location...\n", proj.Path)
-	runPreProcessArtifacts(&artifacts, &proj.Path, &branchName)
-	processArtifacts(config.Home, twd, artifacts, proj.Path, branchName)
-	runPostProcessArtifacts(&artifacts, &proj.Path, &branchName)
+	plog.Debugf("processing artifacts from pick-up location...\n")
+	ac := &pluginapi.ArtifactsContext{BranchContext: bc, Path: artifacts, URL: publicURL(artifactsKey(proj.Path, branchName))}
+	hspan = startStep(bspan, btimer, "hook PreProcessArtifacts")
+	hookErr = runPreProcessArtifacts(hspan, ac)
+	hspan.End(hookErr)
+	if hookFailed(hookErr) {
+		panic(hookErr)
+	} else if hookSkipped(hookErr) {
+		plog.Noticef("no build will be published for this project/branch: %v\n", hookErr)
+		emitEvent("artifactsSkipped", proj.Path, branchName, map[string]interface{}{"path": ac.Path, "reason": hookErr.Error()})
+		artifactsStatus = "skipped"
+		return
+	}
 
-	runPostProcessBranch(&twd, &branchName, &description)
+	// Pre-processing hooks may change where the artifacts are picked up from
+	artifacts = ac.Path
+	emitEvent("preProcessArtifacts", proj.Path, branchName, map[string]interface{}{"path": artifacts})
+	blog.Begin("artifacts")
+	aStart := time.Now()
+	aspan := startSpan(bspan, "artifacts")
+	processArtifacts(config.Home, logDir, artifacts, proj, branchName, commitID, description, tmplValues, aspan, btimer)
+	aspan.End(nil)
+	blog.End(stepStatus(nil))
+	bc.Published = true
+	artifactsStatus = "published"
+	hspan = startStep(bspan, btimer, "hook PostProcessArtifacts")
+	hookErr = runPostProcessArtifacts(hspan, ac, hookResult(nil, time.Since(aStart)))
+	hspan.End(hookErr)
+	emitEvent("postProcessArtifacts", proj.Path, branchName, map[string]interface{}{"path": artifacts, "published": artifactStorage.Describe(artifactsKey(proj.Path, branchName)), "url": ac.URL})
+	if hookFailed(hookErr) {
+		panic(hookErr)
+	}
 }
 
-func runProjectScripts(dir string, branchName string, proj ProjectConfig) {
-	Log.Debugf(" [%s] - project has %d scripts configured\n", proj.Path, len(proj.Scripts))
+func runProjectScripts(config *Configuration, dir string, logDir string, blog *combinedLog, bc *pluginapi.BranchContext, proj ProjectConfig, tmplValues map[string]interface{}, bspan *span, btimer *stepTimer) {
+	branchName := bc.Name
+	plog := projectLog(proj.Path).withBranch(branchName).withPhase("script")
+	plog.Debugf("project has %d scripts configured\n", len(proj.Scripts))
 
 	scriptIndex := 0
 	for _, script := range proj.Scripts {
 
+		slog := plog.withScript(scriptIndex)
+
 		// Setup the variables that can be substituted in the script for this run
-		Log.Debugf(" [%s] - preparing project script %d: \"%s\"...\n", proj.Path, scriptIndex, script)
+		slog.Debugf("preparing project script %d: \"%s\"...\n", scriptIndex, script)
 
-		scriptSubs := scriptVariables{proj.Path, branchName, proj.URL, proj.Artifacts}
+		scriptSubs := templateData(tmplValues, branchVariables(proj, branchName))
 
-		tmpl, err := template.New("script").Parse(script)
-		scriptFinal := &bytes.Buffer{}
-		tmpl.Execute(scriptFinal, scriptSubs)
+		scriptFinalStr, err := expandTemplate("script", script, scriptSubs)
 		if err != nil {
+			reportErrorAndWait(err)
+			slog.Errorf("failed to expand project script %d:\n", scriptIndex)
 			Log.Critical(err)
+			panic(err)
+		}
+		logName := scriptLogName(scriptIndex, script)
+
+		// Pre-script hooks may change the command and its environment, or skip it
+		sc := &pluginapi.ScriptContext{BranchContext: bc, Index: scriptIndex, Command: scriptFinalStr, Env: projectEnv(proj),
+			StdoutLog: logDir + "/" + logName + ".stdout.log", StderrLog: logDir + "/" + logName + ".stderr.log"}
+		hspan := startStep(bspan, btimer, "hook PreScript")
+		hookErr := runPreScript(hspan, sc)
+		hspan.End(hookErr)
+		if hookFailed(hookErr) {
+			panic(hookErr)
+		} else if hookSkipped(hookErr) {
+			slog.Infof("skipping project script %d: %v\n", scriptIndex, hookErr)
+			emitScriptEvent("scriptSkipped", proj.Path, branchName, scriptIndex, map[string]interface{}{"reason": hookErr.Error()})
+			scriptIndex++
+			continue
+		}
+		scriptFinalStr = sc.Command
+		if strings.TrimSpace(scriptFinalStr) == "" {
+			panic(fmt.Errorf("project script %d is empty after its pre-script hooks", scriptIndex))
 		}
-		scriptFinalStr := scriptFinal.String()
 
-		Log.Debugf(" [%s] - executing project script %d: \"%s\"...\n", proj.Path, scriptIndex, scriptFinalStr)
+		slog.Debugf("executing project script %d: \"%s\"...\n", scriptIndex, scriptFinalStr)
+
+		stdout, stderr := openScriptLogs(logDir, logName, slog.fields, blog, config.Log)
+
+		emitScriptEvent("scriptStart", proj.Path, branchName, scriptIndex, map[string]interface{}{
+			"command":   scriptFinalStr,
+			"directory": dir,
+			"stdoutLog": sc.StdoutLog,
+			"stderrLog": sc.StderrLog,
+		})
+
+		sspan := startStep(bspan, btimer, "script "+logName)
+		sspan.SetAttr("script.index", scriptIndex)
+		sspan.SetAttr("script.command", scriptFinalStr)
+
+		sStart := time.Now()
+		blog.Begin(fmt.Sprintf("script %d: %s", scriptIndex, scriptFinalStr))
+		err = execInDir(runContext.Context, dir, scriptFinalStr, sc.Env, stdout, stderr)
+		closeScriptLogs(stdout, stderr)
+		blog.End(scriptStatus(err))
+		sspan.SetAttr("exit_code", exitCode(err))
+		sspan.End(err)
+		slog = slog.withDuration(time.Since(sStart))
+		emitScriptEvent("scriptFinish", proj.Path, branchName, scriptIndex, map[string]interface{}{
+			"exitCode": exitCode(err),
+			"status":   scriptStatus(err),
+			"duration": time.Since(sStart).Seconds(),
+		})
+
+		sc.ExitCode = exitCode(err)
+		hspan = startStep(bspan, btimer, "hook PostScript")
+		hookErr = runPostScript(hspan, sc, hookResult(err, time.Since(sStart)))
+		hspan.End(hookErr)
+		postFailed := hookFailed(hookErr)
 
-		stdout, stderr, err := execInDir(dir, scriptFinalStr)
-		writeProjectLogs(stdout, stderr, scriptIndex, dir)
 		if err != nil {
-			Log.Debugf(" [%s] - error executing project script %d: \"%s\"...\n", proj.Path, scriptIndex, scriptFinalStr)
-			Log.Debugf("%s\n", string(stdout))
-			Log.Errorf("%s\n", string(stderr))
+			slog.Debugf("error executing project script %d: \"%s\"...\n", scriptIndex, scriptFinalStr)
+			slog.Debugf("%s\n", stdout.Tail())
+			slog.Errorf("%s\n", stderr.Tail())
 			Log.Critical(err)
 			panic(err)
 		}
+		if postFailed {
+			panic(hookErr)
+		}
+		slog.Debugf("completed project script %d in: %s\n", scriptIndex, time.Since(sStart))
 
 		scriptIndex++
 	}
 }
 
-func execInDir(dir string, command string) (string, string, error) {
-
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
+// execInDir runs a command in the given directory, killing it if ctx is cancelled
+func execInDir(ctx context.Context, dir string, command string, env []string, stdout io.Writer, stderr io.Writer) error {
 
 	parts := strings.Fields(command)
 
-	cmd := exec.Command(parts[0], parts[1:]...)
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
 	cmd.Dir = dir
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
 	err := cmd.Run()
 
 	if err != nil {
-		raven.CaptureError(err, nil)
-		return stdout.String(), stderr.String(), err
+		reportError(err)
+		return err
 	}
 
-	return stdout.String(), stderr.String(), nil
+	return nil
 }
 
-func writeProjectLogs( stdout string, stderr string, index int, dir string ) {
-	// Open the output log for writing
-	soLogFile, soErr := os.Create( dir + "/go-build-stdout_" + strconv.Itoa(index) + ".log" )
-	if soErr != nil {
-		// Fatal error
-		raven.CaptureErrorAndWait(soErr, nil)
-		Log.Critical(soErr)
+// envValue returns the value of a project environment variable
+func envValue(env EnvVar) string {
+	if env.FromEnv != "" {
+		return os.Getenv(env.FromEnv)
 	}
+	return env.Value
+}
+
+// startProjectSpan starts the span of a project, as a child of the run span
+func startProjectSpan(proj ProjectConfig) *span {
+	pspan := startSpan(runSpan, "project "+proj.Path)
+	pspan.SetAttr("project", proj.Path)
+	pspan.SetAttr("url", proj.URL)
+	return pspan
+}
+
+// projectEventData returns the project configuration included in its events
+func projectEventData(proj ProjectConfig) map[string]interface{} {
+	return map[string]interface{}{
+		"url":       proj.URL,
+		"artifacts": proj.Artifacts,
+		"branches":  proj.Branches,
+		"scripts":   proj.Scripts,
+	}
+}
+
+// projectEnv returns the environment variables for the scripts of a project,
+// in "NAME=value" form: its secrets, then the configured variables
+func projectEnv(proj ProjectConfig) []string {
+	env := secretEnv(proj.Path)
+	for _, e := range proj.Env {
+		env = append(env, e.Name+"="+envValue(e))
+	}
+	return env
+}
 
-	// Write the output to the file
-	_, soErr = soLogFile.WriteString(stdout)
+// openScriptLogs creates the stdout and stderr logs of a script, which its
+// output is streamed to as it runs
+func openScriptLogs(logDir string, name string, fields logFields, blog *combinedLog, cfg LogConfig) (*logStream, *logStream) {
+	stdout, soErr := newLogStream(logDir+"/"+name+".stdout.log", fields, "stdout", blog, cfg)
 	if soErr != nil {
 		// Fatal error
-		raven.CaptureErrorAndWait(soErr, nil)
+		reportErrorAndWait(soErr)
 		Log.Critical(soErr)
+		panic(soErr)
 	}
 
-	soLogFile.Close()
-
-	// Open the error log for writing
-	seLogFile, seErr := os.Create( dir + "/go-build-stderr_" + strconv.Itoa(index) + ".log" )
+	stderr, seErr := newLogStream(logDir+"/"+name+".stderr.log", fields, "stderr", blog, cfg)
 	if seErr != nil {
 		// Fatal error
-		raven.CaptureErrorAndWait(seErr, nil)
+		stdout.Close()
+		reportErrorAndWait(seErr)
 		Log.Critical(seErr)
+		panic(seErr)
 	}
 
-	// Write the output to the file
-	_, seErr = seLogFile.WriteString(stderr)
-	if seErr != nil {
-		// Fatal error
-		raven.CaptureErrorAndWait(seErr, nil)
-		Log.Critical(seErr)
+	return stdout, stderr
+}
+
+func closeScriptLogs(stdout *logStream, stderr *logStream) {
+	if soErr := stdout.Close(); soErr != nil {
+		reportError(soErr)
+		Log.Error(soErr)
 	}
 
-	seLogFile.Close()
+	if seErr := stderr.Close(); seErr != nil {
+		reportError(seErr)
+		Log.Error(seErr)
+	}
 }
 
-func processArtifacts(home string, projectDir string, artifacts string, project string, branchName string) {
-	Log.Infof(" [%s] - processing build artifacts for project \"%s\", branch \"%s\".\n", project, project, branchName)
+func processArtifacts(home string, logDir string, artifacts string, proj ProjectConfig, branchName string, commitID string, description string, tmplValues map[string]interface{}, aspan *span, timer *stepTimer) {
+	project := proj.Path
+	plog := projectLog(project).withBranch(branchName).withPhase("artifacts")
 
-	destination := home + "/artifacts/" + project + "/" + branchName
-	destParts := strings.Split(destination, "/")
-	destParent := strings.Join(destParts[:len(destParts)-1], "/")
+	plog.Infof("processing build artifacts for project \"%s\", branch \"%s\".\n", project, branchName)
 
-	Log.Debugf(" [%s] - build artifacts will be stored in: \"%s\".\n", project, destination)
+	// Everything is assembled in a staging area first, then published through the configured storage
+	staging := home + "/staging/" + project + "/" + branchName
+	destination := staging + "/artifacts"
+	archiveDir := staging + "/archives"
 
-	Log.Debugf(" [%s] - removing any previous artifacts from the destination\n", project)
-	rmErr := os.RemoveAll(destination)
+	plog.Debugf("build artifacts will be staged in: \"%s\".\n", staging)
+
+	mspan := startStep(aspan, timer, "artifacts move")
+
+	plog.Debugf("removing any previous artifacts from the staging area\n")
+	rmErr := os.RemoveAll(staging)
 	if rmErr != nil {
-		raven.CaptureErrorAndWait(rmErr, nil)
+		reportErrorAndWait(rmErr)
 		Log.Critical(rmErr)
 		panic(rmErr)
 	}
 
-	Log.Debugf(" [%s] - creating destination directory structure\n", project)
-	mkErr := os.MkdirAll(destParent, 0755)
+	plog.Debugf("creating staging directory structure\n")
+	mkErr := os.MkdirAll(staging, 0755)
 	if mkErr != nil {
-		raven.CaptureErrorAndWait(mkErr, nil)
+		reportErrorAndWait(mkErr)
 		Log.Critical(mkErr)
 		panic(mkErr)
 	}
 
-	Log.Debugf(" [%s] - moving build artifacts into destination\n", project)
+	plog.Debugf("moving build artifacts into staging area\n")
 	mvErr := os.Rename(artifacts, destination)
 	if mvErr != nil {
-		raven.CaptureErrorAndWait(mvErr, nil)
+		reportErrorAndWait(mvErr)
 		Log.Critical(mvErr)
 		panic(mvErr)
 	}
+	mspan.End(nil)
+
+	// Archives are packed before the logs are added, as the logs differ between runs
+	if len(proj.Archive.Formats) > 0 {
+		arspan := startStep(aspan, timer, "archives")
+		processArchives(destination, archiveDir, proj, branchName, commitID, tmplValues)
+		arspan.End(nil)
+	}
+
+	plog.Debugf("copying build logs from \"%s\"\n", logDir)
+	logCount, lnErr := copyLogs(logDir, destination+"/"+logsDirectory)
+	if lnErr != nil {
+		reportErrorAndWait(lnErr)
+		Log.Critical(lnErr)
+		panic(lnErr)
+	}
+
+	plog.Debugf("project has %d log files\n", logCount)
+
+	mfspan := startStep(aspan, timer, "manifest")
+	manifest := Manifest{
+		Project:     project,
+		Branch:      branchName,
+		Commit:      commitID,
+		Description: description,
+		BuildTime:   time.Now().UTC().Format(time.RFC3339),
+		Version:     Version,
+		Run:         runID,
+	}
+
+	publishManifest(destination, &manifest)
+
+	if len(proj.Archive.Formats) > 0 {
+		archiveManifest := manifest
+		publishManifest(archiveDir, &archiveManifest)
+	}
+	mfspan.End(nil)
+
+	pspan := startStep(aspan, timer, "publish")
+	plog.Infof("publishing build artifacts to %s\n", artifactStorage.Describe(artifactsKey(project, branchName)))
+	pubErr := artifactStorage.Publish(runContext.Context, destination, artifactsKey(project, branchName), plog)
+	if pubErr != nil {
+		reportErrorAndWait(pubErr)
+		Log.Critical(pubErr)
+		panic(pubErr)
+	}
 
-	logGlob := projectDir + "/*.log"
-	Log.Debugf(" [%s] - searching for build logs using glob: \"%s\"\n", project, logGlob)
-	logFiles, lfErr := filepath.Glob(logGlob)
-	if lfErr != nil {
-		raven.CaptureErrorAndWait(lfErr, nil)
-		Log.Critical(lfErr)
-		panic(lfErr)
+	if len(proj.Archive.Formats) > 0 {
+		plog.Infof("publishing archives to %s\n", artifactStorage.Describe(archivesKey(project, branchName)))
+		pubErr = artifactStorage.Publish(runContext.Context, archiveDir, archivesKey(project, branchName), plog)
+		if pubErr != nil {
+			reportErrorAndWait(pubErr)
+			Log.Critical(pubErr)
+			panic(pubErr)
+		}
 	}
+	pspan.End(nil)
 
-	Log.Debugf(" [%s] - project has %d log files\n", project, len(logFiles))
+	plog.Debugf("removing the staging area\n")
+	rmErr = os.RemoveAll(staging)
+	if rmErr != nil {
+		reportError(rmErr)
+		Log.Error(rmErr)
+	}
+
+	plog.Debugf("artifact processing completed, %d files published.\n", len(manifest.Files))
+}
+
+func processArchives(destination string, archiveDir string, proj ProjectConfig, branchName string, commitID string, tmplValues map[string]interface{}) {
+	project := proj.Path
+	plog := projectLog(project).withBranch(branchName).withPhase("artifacts")
+
+	name, nameErr := archiveName(proj.Archive.Name, project, branchName, commitID, tmplValues)
+	if nameErr != nil {
+		reportErrorAndWait(nameErr)
+		Log.Critical(nameErr)
+		panic(nameErr)
+	}
+
+	plog.Infof("packaging build artifacts as \"%s\" (%s)\n", name, strings.Join(proj.Archive.Formats, ", "))
+	archives, arErr := createArchives(destination, archiveDir, name, proj.Archive.Formats)
+	if arErr != nil {
+		reportErrorAndWait(arErr)
+		Log.Critical(arErr)
+		panic(arErr)
+	}
 
-	for _, f := range logFiles {
-		lFile := filepath.Base(f)
-		Log.Debugf(" [%s] - moving log file \"%s\" to destination\n", project, lFile)
-		mvLfErr := os.Rename(f, destination + "/" + lFile)
-			if mvLfErr != nil {
-			raven.CaptureErrorAndWait(mvLfErr, nil)
-			Log.Critical(mvLfErr)
-			panic(mvLfErr)
+	for _, a := range archives {
+		plog.Debugf("created archive \"%s\"\n", a)
+
+		if signingKey != nil {
+			plog.Debugf("signing archive \"%s\"\n", a)
+			sigErr := signFile(signingKey, a)
+			if sigErr != nil {
+				reportErrorAndWait(sigErr)
+				Log.Critical(sigErr)
+				panic(sigErr)
+			}
+		}
+	}
+
+	if proj.Archive.Only {
+		// The destination is kept (empty) so the build logs still have somewhere to go
+		plog.Debugf("project publishes archives only, removing unpacked artifacts\n")
+		rmErr := os.RemoveAll(destination)
+		if rmErr != nil {
+			reportErrorAndWait(rmErr)
+			Log.Critical(rmErr)
+			panic(rmErr)
+		}
+
+		mkErr := os.MkdirAll(destination, 0755)
+		if mkErr != nil {
+			reportErrorAndWait(mkErr)
+			Log.Critical(mkErr)
+			panic(mkErr)
 		}
 	}
+}
+
+// publishManifest writes the manifest and checksums for a published directory,
+// and signs the manifest when a signing key is configured
+func publishManifest(dir string, manifest *Manifest) {
+	plog := projectLog(manifest.Project).withBranch(manifest.Branch).withPhase("artifacts")
+	plog.Debugf("writing manifest and checksums into \"%s\"\n", dir)
+	mfErr := writeManifest(dir, manifest)
+	if mfErr != nil {
+		reportErrorAndWait(mfErr)
+		Log.Critical(mfErr)
+		panic(mfErr)
+	}
 
-	Log.Debugf(" [%s] - artifact processing completed.\n", project)
+	if signingKey == nil {
+		return
+	}
+
+	plog.Debugf("signing manifest in \"%s\"\n", dir)
+	sigErr := signFile(signingKey, dir+"/"+manifestFile)
+	if sigErr != nil {
+		reportErrorAndWait(sigErr)
+		Log.Critical(sigErr)
+		panic(sigErr)
+	}
 }
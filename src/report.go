@@ -0,0 +1,289 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// report - Opt-in, pluggable error reporting
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/getsentry/raven-go"
+)
+
+// errorReportFile is the file the file reporter writes to, in the log directory
+// of the run, when no other path is configured
+const errorReportFile = "errors.json"
+
+// ErrorReporter receives the errors and panics of a run. Reporting is off
+// unless a reporter is configured, and every report is scrubbed first.
+type ErrorReporter interface {
+	// Report records a scrubbed error report, waiting for it to be delivered
+	// when wait is set (as the process is about to exit)
+	Report(report *errorReport, wait bool)
+
+	// Close delivers any outstanding reports
+	Close()
+}
+
+// errorReport is a single scrubbed error or panic
+type errorReport struct {
+	Time    string            `json:"time"`
+	Level   string            `json:"level"`
+	Type    string            `json:"type"`
+	Message string            `json:"message"`
+	Run     string            `json:"run"`
+	Tags    map[string]string `json:"tags"`
+	Stack   []string          `json:"stack,omitempty"`
+}
+
+// errorReporter is the configured reporter, none until configureReporting
+var errorReporter ErrorReporter = noneReporter{}
+
+var (
+	// scrubURLPattern matches URLs, including scp-like git URLs (user@host:path)
+	scrubURLPattern = regexp.MustCompile(`[A-Za-z][A-Za-z0-9+.-]*://[^\s"'<>]+|[\w.-]+@[\w.-]+:[\w./~-]+`)
+
+	// scrubCredentialPattern matches credentials given as key=value or key: value
+	scrubCredentialPattern = regexp.MustCompile(`(?i)\b(password|passwd|pwd|token|secret|api[_-]?key|access[_-]?key|auth|authorization)(\s*[=:]\s*)(?:(?:bearer|basic)\s+)?[^\s"',;]+`)
+
+	// scrubSchemePattern matches HTTP authorization credentials
+	scrubSchemePattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+
+	// scrubPathPattern matches absolute file system paths
+	scrubPathPattern = regexp.MustCompile(`(?:[A-Za-z]:\\|\\\\|/)[^\s"'<>:]*[/\\][^\s"'<>:]*|~/[^\s"'<>:]+`)
+)
+
+// scrubReport removes secrets, URLs, credentials and paths from report text
+func scrubReport(s string) string {
+	s = maskSecrets(s)
+	s = scrubURLPattern.ReplaceAllString(s, "<url>")
+	s = scrubCredentialPattern.ReplaceAllString(s, "$1$2<redacted>")
+	s = scrubSchemePattern.ReplaceAllString(s, "$1 <redacted>")
+	s = scrubPathPattern.ReplaceAllString(s, "<path>")
+	return s
+}
+
+// reportTags returns the tags attached to every report
+func reportTags() map[string]string {
+	return map[string]string{
+		"version":   Version,
+		"buildtime": BuildTime,
+		"goos":      runtime.GOOS,
+		"goarch":    runtime.GOARCH,
+	}
+}
+
+// newErrorReport creates a scrubbed report of err, with the stack of the
+// caller skip frames up (1 being the caller of newErrorReport)
+func newErrorReport(level string, err error, skip int) *errorReport {
+	report := &errorReport{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Level:   level,
+		Type:    fmt.Sprintf("%T", err),
+		Message: scrubReport(err.Error()),
+		Run:     runID,
+		Tags:    reportTags(),
+	}
+
+	pc := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(skip+1, pc)])
+	for {
+		frame, more := frames.Next()
+		report.Stack = append(report.Stack, fmt.Sprintf("%s (%s:%d)", frame.Function, filepath.Base(frame.File), frame.Line))
+		if !more {
+			break
+		}
+	}
+
+	return report
+}
+
+// reportError reports a non-fatal error
+func reportError(err error) {
+	if err != nil {
+		errorReporter.Report(newErrorReport("error", err, 2), false)
+	}
+}
+
+// reportErrorAndWait reports a fatal error, waiting for the report to be
+// delivered before returning
+func reportErrorAndWait(err error) {
+	if err != nil {
+		errorReporter.Report(newErrorReport("fatal", err, 2), true)
+	}
+}
+
+// reportPanic calls f, recovering from and reporting any panic, which is
+// logged and returned
+func reportPanic(f func()) (recovered interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			recovered = r
+			err, ok := r.(error)
+			if !ok {
+				err = fmt.Errorf("%v", r)
+			}
+			Log.Errorf("Recovered from a panic: %v", err)
+			errorReporter.Report(newErrorReport("panic", err, 3), false)
+		}
+	}()
+
+	f()
+	return nil
+}
+
+// configureReporting sets up the error reporter from the configuration. The
+// legacy metrics and ravendsn settings still select Sentry with that DSN.
+func configureReporting(config *Configuration) error {
+	cfg := config.Reporting
+	if cfg.Type == "" && config.Metrics && config.RavenDSN != "" {
+		Log.Warning("The metrics and ravendsn settings are deprecated, use reporting instead")
+		cfg.Type, cfg.DSN = "sentry", config.RavenDSN
+	} else if cfg.Type == "" && config.Metrics {
+		Log.Warning("metrics is set without a ravendsn, but there is no longer a built-in DSN; error reporting is disabled")
+	}
+
+	switch cfg.Type {
+	case "", "none":
+		Log.Info("Error reporting is disabled")
+		errorReporter = noneReporter{}
+
+	case "file":
+		path := cfg.Path
+		if path == "" {
+			path = config.Home + "/logs/" + runID + "/" + errorReportFile
+		}
+		Log.Infof("Error reports will be written to \"%s\"", path)
+		errorReporter = &fileReporter{path: path}
+
+	case "sentry":
+		if cfg.DSN == "" {
+			return errors.New("sentry error reporting requires a reporting.dsn")
+		}
+		client, err := raven.New(cfg.DSN)
+		if err != nil {
+			return err
+		}
+		client.SetRelease(Version)
+		client.SetTagsContext(reportTags())
+		Log.Info("Error reporting to Sentry is enabled using the configured DSN")
+		errorReporter = &sentryReporter{client: client}
+
+	default:
+		return errors.New("unknown error reporting type \"" + cfg.Type + "\", expected none, file or sentry")
+	}
+
+	return nil
+}
+
+// noneReporter discards every report
+type noneReporter struct{}
+
+func (noneReporter) Report(report *errorReport, wait bool) {}
+
+func (noneReporter) Close() {}
+
+// fileReporter appends reports to a local file, one JSON object per line
+type fileReporter struct {
+	lock sync.Mutex
+	path string
+}
+
+func (r *fileReporter) Report(report *errorReport, wait bool) {
+	var line bytes.Buffer
+	enc := json.NewEncoder(&line)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(report); err != nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		Log.Errorf("Failed to write error report: %v", err)
+		return
+	}
+
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		Log.Errorf("Failed to write error report: %v", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(line.Bytes()); err != nil {
+		Log.Errorf("Failed to write error report: %v", err)
+	}
+}
+
+func (r *fileReporter) Close() {}
+
+// sentryReporter sends reports to Sentry with the user-supplied DSN. Only the
+// scrubbed report is sent: the stack carries file names without their paths,
+// and no source context.
+type sentryReporter struct {
+	client *raven.Client
+}
+
+func (r *sentryReporter) Report(report *errorReport, wait bool) {
+	stack := &raven.Stacktrace{}
+	for i := len(report.Stack) - 1; i >= 0; i-- {
+		stack.Frames = append(stack.Frames, &raven.StacktraceFrame{Function: report.Stack[i], InApp: true})
+	}
+
+	exception := raven.NewException(errors.New(report.Message), stack)
+	exception.Type = report.Type
+
+	packet := raven.NewPacket(report.Message, exception)
+	packet.Level = raven.ERROR
+	if report.Level != "error" {
+		packet.Level = raven.FATAL
+	}
+
+	_, ch := r.client.Capture(packet, map[string]string{"run": report.Run})
+	if wait && ch != nil {
+		<-ch
+	}
+}
+
+func (r *sentryReporter) Close() {
+	r.client.Wait()
+	r.client.Close()
+}
+
+// closeErrorReporter delivers any outstanding reports at the end of the run
+func closeErrorReporter() {
+	errorReporter.Close()
+}
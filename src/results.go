@@ -0,0 +1,125 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// results - Outcome of every project and branch of a run
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Danw33/go-build/pluginapi"
+)
+
+// resultsFile is the file the outcome of every project and branch is written
+// to, in the log directory of the run
+const resultsFile = "results.json"
+
+// workResult is the outcome of a project, or of one of its branches
+type workResult struct {
+	Project   string           `json:"project"`
+	Branch    string           `json:"branch,omitempty"`
+	Status    pluginapi.Status `json:"status"`
+	Reason    string           `json:"reason,omitempty"`
+	Artifacts string           `json:"artifacts,omitempty"`
+	Seconds   float64          `json:"seconds"`
+}
+
+// runResults holds the outcome of every project and branch of the run
+var runResults struct {
+	lock    sync.Mutex
+	results []workResult
+}
+
+// recordResult records the outcome of a project, or of a branch if one is
+// given, along with what became of its artifacts
+func recordResult(project string, branch string, result *pluginapi.Result, artifacts string) {
+	wr := workResult{Project: project, Branch: branch, Status: result.Status, Artifacts: artifacts, Seconds: result.Duration.Seconds()}
+	if result.Err != nil {
+		wr.Reason = maskSecrets(result.Err.Error())
+	}
+
+	runResults.lock.Lock()
+	runResults.results = append(runResults.results, wr)
+	runResults.lock.Unlock()
+}
+
+// logRunResults logs how many branches succeeded, failed or were skipped, and
+// why each that didn't succeed
+func logRunResults() {
+	runResults.lock.Lock()
+	results := append([]workResult(nil), runResults.results...)
+	runResults.lock.Unlock()
+
+	if len(results) == 0 {
+		return
+	}
+
+	counts := map[pluginapi.Status]int{}
+	var lines []string
+	for _, r := range results {
+		name := r.Project
+		if r.Branch != "" {
+			counts[r.Status]++
+			name += "/" + r.Branch
+		}
+		if r.Status != pluginapi.StatusSucceeded {
+			lines = append(lines, fmt.Sprintf("  %s %s: %s", name, r.Status, r.Reason))
+		}
+	}
+
+	summary := fmt.Sprintf("Branches: %d succeeded, %d failed, %d skipped", counts[pluginapi.StatusSucceeded], counts[pluginapi.StatusFailed], counts[pluginapi.StatusSkipped])
+	if len(lines) == 0 {
+		Log.Infof("%s", summary)
+		return
+	}
+	Log.Infof("%s\n%s", summary, strings.Join(lines, "\n"))
+}
+
+// writeResults writes the outcome of every project and branch of the run, in
+// the order they finished, to the log directory of the run
+func writeResults(home string) error {
+	runResults.lock.Lock()
+	defer runResults.lock.Unlock()
+
+	if len(runResults.results) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(runResults.results, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := home + "/logs/" + runID
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dir+"/"+resultsFile, append(data, '\n'), 0644)
+}
@@ -0,0 +1,593 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// rpcplugin - Executable plugins spoken to over JSON-RPC
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Danw33/go-build/pluginapi"
+)
+
+const (
+	// goPluginType is the type of plugin list entries that are Go plugins, the default
+	goPluginType = "go"
+
+	// rpcPluginType is the type of plugin list entries that are executables
+	rpcPluginType = "exec"
+
+	// rpcDefaultTimeout is how long a plugin process has to answer a call,
+	// unless a timeout is configured
+	rpcDefaultTimeout = 5 * time.Minute
+
+	// rpcShutdownTimeout is how long a plugin process has to exit once asked to
+	rpcShutdownTimeout = 10 * time.Second
+
+	// rpcMethodNotFound is the JSON-RPC error code for a method a plugin doesn't implement
+	rpcMethodNotFound = -32601
+)
+
+// rpcRequest is a JSON-RPC 2.0 request sent to a plugin process, or a
+// notification when it has no ID
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int64       `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response read from a plugin process
+type rpcResponse struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// rpcError is the error of a response, whose data may give the severity of a
+// hook error: "warn" (the default), "skip", "fail" or "abort"
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Severity string `json:"severity"`
+	} `json:"data"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// hookError returns the error as returned by a hook
+func (e *rpcError) hookError() error {
+	err := errors.New(e.Message)
+	switch e.Data.Severity {
+	case pluginapi.SeveritySkip.String():
+		return pluginapi.Skip(e.Message)
+	case pluginapi.SeverityFailBranch.String():
+		return pluginapi.FailBranch(err)
+	case pluginapi.SeverityAbortRun.String():
+		return pluginapi.AbortRun(err)
+	}
+	return err
+}
+
+// rpcParams are the parameters of a hook call, giving the parts of its context
+// that apply to it
+type rpcParams struct {
+	Run       *pluginapi.RunContext `json:"run"`
+	Project   *ProjectConfig        `json:"project,omitempty"`
+	Dir       string                `json:"dir,omitempty"`
+	Branch    *rpcBranch            `json:"branch,omitempty"`
+	Script    *rpcScript            `json:"script,omitempty"`
+	Artifacts *rpcArtifacts         `json:"artifacts,omitempty"`
+	Result    *rpcResult            `json:"result,omitempty"`
+	Config    json.RawMessage       `json:"config,omitempty"`
+}
+
+// rpcBranch is the branch of a hook call
+type rpcBranch struct {
+	Name        string `json:"name"`
+	Commit      string `json:"commit"`
+	Description string `json:"description"`
+	Published   bool   `json:"published"`
+}
+
+// rpcScript is the script of a hook call
+type rpcScript struct {
+	Index     int      `json:"index"`
+	Command   string   `json:"command"`
+	Env       []string `json:"env"`
+	StdoutLog string   `json:"stdoutLog"`
+	StderrLog string   `json:"stderrLog"`
+	ExitCode  int      `json:"exitCode"`
+}
+
+// rpcArtifacts are the artifacts of a hook call
+type rpcArtifacts struct {
+	Path string `json:"path"`
+	URL  string `json:"url"`
+}
+
+// rpcResult is the result passed to a post-processing hook call
+type rpcResult struct {
+	Status   pluginapi.Status `json:"status"`
+	Error    string           `json:"error,omitempty"`
+	Duration float64          `json:"duration"`
+}
+
+// rpcChanges are returned by pre-processing hook calls to change the project
+// configuration, a script or the artifact path. Only the fields given are changed
+type rpcChanges struct {
+	Project *struct {
+		Artifacts *string   `json:"artifacts"`
+		Branches  *[]string `json:"branches"`
+		Scripts   *[]string `json:"scripts"`
+	} `json:"project"`
+	Script *struct {
+		Command *string   `json:"command"`
+		Env     *[]string `json:"env"`
+	} `json:"script"`
+	Path *string `json:"path"`
+}
+
+// rpcPlugin is a plugin running in its own process, which is sent one JSON-RPC
+// request per line on its standard input and answers on its standard output.
+// Calls are made one at a time; if the process exits or fails to answer in
+// time, every later call fails with a warning
+type rpcPlugin struct {
+	path    string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.ReadCloser
+	stderr  *lineLogger
+	timeout time.Duration
+
+	// responses are read from the process until it closes its standard output
+	responses chan *rpcResponse
+	exited    chan struct{}
+
+	mu        sync.Mutex
+	nextID    int64
+	hooks     map[string]bool
+	variables []string
+	failed    error
+}
+
+// startRPCPlugin starts the process of an executable plugin. Anything it
+// writes to its standard error is logged
+func startRPCPlugin(entry PluginConfig) (*rpcPlugin, error) {
+	p := &rpcPlugin{
+		path:      entry.Path,
+		cmd:       exec.Command(entry.Path, entry.Args...),
+		timeout:   rpcDefaultTimeout,
+		responses: make(chan *rpcResponse),
+		exited:    make(chan struct{}),
+	}
+	if entry.Timeout > 0 {
+		p.timeout = time.Duration(entry.Timeout) * time.Second
+	}
+
+	// Don't wait on anything the process started that still holds its output
+	p.cmd.WaitDelay = rpcShutdownTimeout
+
+	p.stderr = &lineLogger{log: projectLog("").withPhase("plugin"), prefix: pluginEntryName(entry) + ": "}
+	p.cmd.Stderr = p.stderr
+
+	var err error
+	if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if p.stdout, err = p.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin \"%s\": %v", entry.Path, err)
+	}
+
+	go p.read()
+	return p, nil
+}
+
+// read passes the responses of the process to callers until it closes its
+// standard output, then waits for it to exit
+func (p *rpcPlugin) read() {
+	plog := projectLog("").withPhase("plugin")
+	r := bufio.NewReader(p.stdout)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			resp := &rpcResponse{}
+			if jsonErr := json.Unmarshal(line, resp); jsonErr != nil {
+				plog.Warningf("plugin \"%s\" wrote something other than a JSON-RPC response: %s", p.path, bytes.TrimSpace(line))
+			} else {
+				p.responses <- resp
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	close(p.responses)
+	err := p.cmd.Wait()
+	p.stderr.Flush()
+	if err != nil {
+		plog.Warningf("plugin \"%s\" exited: %v", p.path, err)
+	}
+	close(p.exited)
+}
+
+// call sends a request to the process and waits for its response, which is
+// decoded into result if it isn't nil
+func (p *rpcPlugin) call(method string, params interface{}, result interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failed != nil {
+		return p.failed
+	}
+
+	p.nextID++
+	id := p.nextID
+	if err := p.send(rpcRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params}); err != nil {
+		return p.fail(err)
+	}
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case resp, ok := <-p.responses:
+			if !ok {
+				return p.fail(fmt.Errorf("plugin process exited"))
+			}
+			if resp.ID != id {
+				// A late answer to a call that timed out
+				continue
+			}
+			if resp.Error != nil {
+				return resp.Error
+			}
+			if result == nil || len(resp.Result) == 0 {
+				return nil
+			}
+			return json.Unmarshal(resp.Result, result)
+
+		case <-timer.C:
+			p.kill()
+			return p.fail(fmt.Errorf("plugin did not answer %s within %s, and was stopped", method, p.timeout))
+		}
+	}
+}
+
+// send writes a request to the standard input of the process
+func (p *rpcPlugin) send(req rpcRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = p.stdin.Write(append(data, '\n'))
+	return err
+}
+
+// fail records that the process can no longer be called
+func (p *rpcPlugin) fail(err error) error {
+	p.failed = err
+	return err
+}
+
+// kill stops the process without waiting for it to answer
+func (p *rpcPlugin) kill() {
+	p.cmd.Process.Kill()
+	p.stdout.Close()
+}
+
+// hook calls a hook method, returning its error as the hook's
+func (p *rpcPlugin) hook(method string, params rpcParams, result interface{}) error {
+	err := p.call(method, params, result)
+	if rerr, ok := err.(*rpcError); ok {
+		return rerr.hookError()
+	}
+	return err
+}
+
+// implements returns whether the plugin declared a hook on initialisation, or
+// for TemplateValues, any template variables
+func (p *rpcPlugin) implements(hook string) bool {
+	if hook == templateValuesHook {
+		return len(p.variables) > 0
+	}
+	return p.hooks == nil || p.hooks[hook]
+}
+
+// masksSecrets marks the plugin as masking the contexts it sends, see projectParams
+func (p *rpcPlugin) masksSecrets() {}
+
+// PluginInit initialises the plugin, which answers with the hooks it
+// implements; if it doesn't list them, it is called for every hook. It may
+// also list the template variables it adds
+func (p *rpcPlugin) PluginInit(ctx context.Context, config []byte) error {
+	var result struct {
+		Hooks     []string `json:"hooks"`
+		Variables []string `json:"variables"`
+	}
+
+	params := map[string]interface{}{"apiVersion": pluginapi.Version, "run": runContext, "config": json.RawMessage(config)}
+	if len(config) == 0 {
+		delete(params, "config")
+	}
+	if err := p.call("PluginInit", params, &result); err != nil {
+		return err
+	}
+
+	if result.Hooks != nil {
+		p.hooks = map[string]bool{}
+		for _, h := range result.Hooks {
+			p.hooks[h] = true
+		}
+	}
+	p.variables = result.Variables
+	return nil
+}
+
+// ConcurrentSafe is true, as calls are serialized on the connection to the
+// process rather than by the core
+func (p *rpcPlugin) ConcurrentSafe() bool {
+	return true
+}
+
+// TemplateVariables returns the template variables the plugin listed on
+// initialisation
+func (p *rpcPlugin) TemplateVariables() []string {
+	return p.variables
+}
+
+// TemplateValues asks the plugin for the values of its template variables for
+// a branch
+func (p *rpcPlugin) TemplateValues(bc *pluginapi.BranchContext) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	err := p.hook(templateValuesHook, branchParams(bc), &values)
+	return values, err
+}
+
+// ConfigSchema asks the plugin for the JSON Schema of its config section,
+// returning nil if it doesn't have one. It is the one call made before
+// PluginInit, as validate never initialises plugins
+func (p *rpcPlugin) ConfigSchema() []byte {
+	var schema json.RawMessage
+	err := p.call("ConfigSchema", nil, &schema)
+	if rerr, ok := err.(*rpcError); ok && rerr.Code == rpcMethodNotFound {
+		return nil
+	}
+	if err != nil {
+		Log.Errorf("Plugin \"%s\" failed to give its config schema: %v", p.path, err)
+		return nil
+	}
+	if len(schema) == 0 || string(schema) == "null" {
+		return nil
+	}
+	return schema
+}
+
+// Close asks the process to shut down, and stops it if it doesn't in time
+func (p *rpcPlugin) Close() error {
+	p.mu.Lock()
+	if p.failed == nil {
+		p.send(rpcRequest{JSONRPC: "2.0", Method: "Shutdown"})
+		p.failed = fmt.Errorf("plugin has been shut down")
+	}
+	p.stdin.Close()
+	p.mu.Unlock()
+
+	// Discard any answers to calls that timed out, so the process can be waited for
+	go func() {
+		for range p.responses {
+		}
+	}()
+
+	select {
+	case <-p.exited:
+	case <-time.After(rpcShutdownTimeout):
+		p.kill()
+		<-p.exited
+	}
+	return nil
+}
+
+func (p *rpcPlugin) PostLoadPlugins(run *pluginapi.RunContext) error {
+	return p.hook("PostLoadPlugins", rpcParams{Run: run}, nil)
+}
+
+func (p *rpcPlugin) PreProcessProjects(run *pluginapi.RunContext) error {
+	return p.hook("PreProcessProjects", rpcParams{Run: run}, nil)
+}
+
+func (p *rpcPlugin) PostProcessProjects(run *pluginapi.RunContext, result *pluginapi.Result) error {
+	return p.hook("PostProcessProjects", rpcParams{Run: run, Result: newRPCResult(result)}, nil)
+}
+
+func (p *rpcPlugin) ConfigureProject(pc *pluginapi.ProjectContext, config []byte) error {
+	params := projectParams(pc)
+	if len(config) > 0 {
+		params.Config = config
+	}
+	return p.hook("ConfigureProject", params, nil)
+}
+
+func (p *rpcPlugin) PreProcessProject(pc *pluginapi.ProjectContext) error {
+	params := projectParams(pc)
+	var changes rpcChanges
+	err := p.hook("PreProcessProject", params, &changes)
+	if changes.Project != nil {
+		changed := changes.Project
+		if changed.Artifacts != nil {
+			pc.Project.Artifacts = *changed.Artifacts
+		}
+		if changed.Branches != nil {
+			pc.Project.Branches = *changed.Branches
+		}
+		// The scripts sent are masked, so they are only taken back if changed
+		if changed.Scripts != nil && !reflect.DeepEqual(*changed.Scripts, params.Project.Scripts) {
+			pc.Project.Scripts = *changed.Scripts
+		}
+	}
+	return err
+}
+
+func (p *rpcPlugin) PostProcessProject(pc *pluginapi.ProjectContext, result *pluginapi.Result) error {
+	params := projectParams(pc)
+	params.Result = newRPCResult(result)
+	return p.hook("PostProcessProject", params, nil)
+}
+
+func (p *rpcPlugin) PreProcessBranch(bc *pluginapi.BranchContext) error {
+	return p.hook("PreProcessBranch", branchParams(bc), nil)
+}
+
+func (p *rpcPlugin) PostProcessBranch(bc *pluginapi.BranchContext, result *pluginapi.Result) error {
+	params := branchParams(bc)
+	params.Result = newRPCResult(result)
+	return p.hook("PostProcessBranch", params, nil)
+}
+
+func (p *rpcPlugin) PreScript(sc *pluginapi.ScriptContext) error {
+	params := scriptParams(sc)
+	var changes rpcChanges
+	err := p.hook("PreScript", params, &changes)
+	if changes.Script != nil {
+		// The command and env sent are masked, so they are only taken back if changed
+		changed := changes.Script
+		if changed.Command != nil && *changed.Command != params.Script.Command {
+			sc.Command = *changed.Command
+		}
+		if changed.Env != nil && !reflect.DeepEqual(*changed.Env, params.Script.Env) {
+			sc.Env = *changed.Env
+		}
+	}
+	return err
+}
+
+func (p *rpcPlugin) PostScript(sc *pluginapi.ScriptContext, result *pluginapi.Result) error {
+	params := scriptParams(sc)
+	params.Result = newRPCResult(result)
+	return p.hook("PostScript", params, nil)
+}
+
+func (p *rpcPlugin) PreProcessArtifacts(ac *pluginapi.ArtifactsContext) error {
+	params := artifactsParams(ac)
+	var changes rpcChanges
+	err := p.hook("PreProcessArtifacts", params, &changes)
+
+	// The path sent is masked, so it is only taken back if changed
+	if changes.Path != nil && *changes.Path != params.Artifacts.Path {
+		ac.Path = *changes.Path
+	}
+	return err
+}
+
+func (p *rpcPlugin) PostProcessArtifacts(ac *pluginapi.ArtifactsContext, result *pluginapi.Result) error {
+	params := artifactsParams(ac)
+	params.Result = newRPCResult(result)
+	return p.hook("PostProcessArtifacts", params, nil)
+}
+
+// projectParams returns the parameters of a project hook call. The project
+// configuration is sent with its secrets masked
+func projectParams(pc *pluginapi.ProjectContext) rpcParams {
+	return rpcParams{Run: pc.RunContext, Project: maskedProject(pc.Project), Dir: pc.Dir}
+}
+
+// branchParams returns the parameters of a branch hook call
+func branchParams(bc *pluginapi.BranchContext) rpcParams {
+	params := projectParams(bc.ProjectContext)
+	params.Branch = &rpcBranch{Name: bc.Name, Commit: bc.Commit, Description: maskSecrets(bc.Description), Published: bc.Published}
+	return params
+}
+
+// scriptParams returns the parameters of a script hook call, with the command
+// and env masked
+func scriptParams(sc *pluginapi.ScriptContext) rpcParams {
+	params := branchParams(sc.BranchContext)
+	params.Script = &rpcScript{Index: sc.Index, Command: maskSecrets(sc.Command), Env: *maskedStrings(&sc.Env),
+		StdoutLog: sc.StdoutLog, StderrLog: sc.StderrLog, ExitCode: sc.ExitCode}
+	return params
+}
+
+// artifactsParams returns the parameters of an artifacts hook call, with the
+// path and url masked
+func artifactsParams(ac *pluginapi.ArtifactsContext) rpcParams {
+	params := branchParams(ac.BranchContext)
+	params.Artifacts = &rpcArtifacts{Path: maskSecrets(ac.Path), URL: maskSecrets(ac.URL)}
+	return params
+}
+
+// newRPCResult returns the result of a post-processing hook call
+func newRPCResult(result *pluginapi.Result) *rpcResult {
+	r := &rpcResult{Status: result.Status, Duration: result.Duration.Seconds()}
+	if result.Err != nil {
+		r.Error = maskSecrets(result.Err.Error())
+	}
+	return r
+}
+
+// lineLogger is a writer logging each line written to it
+type lineLogger struct {
+	log    fieldLogger
+	prefix string
+	buf    []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		l.log.Infof("%s%s", l.prefix, bytes.TrimRight(l.buf[:i], "\r"))
+		l.buf = l.buf[i+1:]
+	}
+}
+
+// Flush logs the last line written, if it wasn't ended
+func (l *lineLogger) Flush() {
+	if len(l.buf) > 0 {
+		l.log.Infof("%s%s", l.prefix, l.buf)
+		l.buf = nil
+	}
+}
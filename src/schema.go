@@ -0,0 +1,207 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// schema - Validation of plugin config sections against a JSON Schema
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// validateSchema checks a JSON document against a JSON Schema, returning a
+// description of each problem found. Only the keywords plugins need to describe
+// a config section are supported: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minimum, maximum, minLength,
+// maxLength and pattern; any others are ignored
+func validateSchema(schema []byte, doc []byte, path string) ([]string, error) {
+	var s, v interface{}
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema: %v", err)
+	}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	checkSchema(s, v, path, &problems)
+	return problems, nil
+}
+
+// checkSchema checks a decoded JSON value against a decoded schema
+func checkSchema(schema interface{}, v interface{}, path string, problems *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		// Boolean schemas accept or reject anything
+		if schema == false {
+			fail("no value is allowed here")
+		}
+		return
+	}
+
+	if t, ok := s["type"]; ok && !schemaTypeMatches(t, v) {
+		fail("expected %v, found %s", t, jsonType(v))
+		return
+	}
+
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, v) {
+		fail("must be %v", c)
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || reflect.DeepEqual(e, v)
+		}
+		if !found {
+			fail("must be one of %v", enum)
+		}
+	}
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		checkObject(s, value, path, problems)
+
+	case []interface{}:
+		if n, ok := s["minItems"].(float64); ok && float64(len(value)) < n {
+			fail("must have at least %v items", n)
+		}
+		if n, ok := s["maxItems"].(float64); ok && float64(len(value)) > n {
+			fail("must have at most %v items", n)
+		}
+		if items, ok := s["items"]; ok {
+			for i, item := range value {
+				checkSchema(items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+
+	case string:
+		if n, ok := s["minLength"].(float64); ok && float64(len([]rune(value))) < n {
+			fail("must be at least %v characters", n)
+		}
+		if n, ok := s["maxLength"].(float64); ok && float64(len([]rune(value))) > n {
+			fail("must be at most %v characters", n)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				fail("schema pattern %q is invalid: %v", pattern, err)
+			} else if !re.MatchString(value) {
+				fail("must match %q", pattern)
+			}
+		}
+
+	case float64:
+		if n, ok := s["minimum"].(float64); ok && value < n {
+			fail("must be at least %v", n)
+		}
+		if n, ok := s["maximum"].(float64); ok && value > n {
+			fail("must be at most %v", n)
+		}
+	}
+}
+
+// checkObject checks the properties of an object against a schema
+func checkObject(s map[string]interface{}, value map[string]interface{}, path string, problems *[]string) {
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, ok := value[name]; !ok {
+					*problems = append(*problems, fmt.Sprintf("%s: missing required property %q", path, name))
+				}
+			}
+		}
+	}
+
+	properties, _ := s["properties"].(map[string]interface{})
+	additional, hasAdditional := s["additionalProperties"]
+
+	// Properties are checked in order, so problems are reported consistently
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if ps, ok := properties[name]; ok {
+			checkSchema(ps, value[name], path+"."+name, problems)
+		} else if hasAdditional {
+			if additional == false {
+				*problems = append(*problems, fmt.Sprintf("%s: unknown property %q", path, name))
+			} else {
+				checkSchema(additional, value[name], path+"."+name, problems)
+			}
+		}
+	}
+}
+
+// schemaTypeMatches returns whether a value is of the type, or one of the
+// types, given by a schema
+func schemaTypeMatches(t interface{}, v interface{}) bool {
+	types, ok := t.([]interface{})
+	if !ok {
+		types = []interface{}{t}
+	}
+
+	actual := jsonType(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type name of a decoded JSON value
+func jsonType(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return strings.ToLower(fmt.Sprintf("%T", v))
+}
@@ -0,0 +1,228 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// secrets - Encrypted secrets file for build credentials
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// defaultSecretsFile is the secrets file used when none is configured
+	defaultSecretsFile = ".build.secrets"
+
+	// defaultSecretsKeyEnv is the environment variable holding the secrets key
+	// when neither a key file nor another variable is configured
+	defaultSecretsKeyEnv = "GO_BUILD_SECRETS_KEY"
+)
+
+// secretNamePattern restricts secret names to valid environment variable names
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// buildSecrets holds the decrypted secrets for this run
+var buildSecrets = &secretsFile{}
+
+// secretsFile is the decrypted content of the secrets file. Global secrets are
+// available to every project, and project secrets only to the project of that
+// path, overriding any global secret of the same name.
+type secretsFile struct {
+	Global   map[string]string            `json:"global"`
+	Projects map[string]map[string]string `json:"projects"`
+}
+
+// forProject returns the secrets available to the scripts of a project
+func (f *secretsFile) forProject(project string) map[string]string {
+	res := make(map[string]string)
+	for name, value := range f.Global {
+		res[name] = value
+	}
+	for name, value := range f.Projects[project] {
+		res[name] = value
+	}
+	return res
+}
+
+// scope returns the secrets of the given project, or the global secrets when
+// project is empty, creating the map if create is set
+func (f *secretsFile) scope(project string, create bool) map[string]string {
+	if project == "" {
+		if f.Global == nil && create {
+			f.Global = make(map[string]string)
+		}
+		return f.Global
+	}
+
+	if f.Projects == nil && create {
+		f.Projects = make(map[string]map[string]string)
+	}
+	if f.Projects[project] == nil && create {
+		f.Projects[project] = make(map[string]string)
+	}
+	return f.Projects[project]
+}
+
+// secretEnv returns the secrets of a project in "NAME=value" form, sorted by name
+func secretEnv(project string) []string {
+	var env []string
+	for name, value := range buildSecrets.forProject(project) {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// secretsPath returns the configured secrets file path
+func secretsPath(cfg SecretsConfig) string {
+	if cfg.Path != "" {
+		return cfg.Path
+	}
+	return defaultSecretsFile
+}
+
+// readSecretsKey loads the 32-byte secrets key, base64 encoded, from the
+// configured key file or environment variable
+func readSecretsKey(cfg SecretsConfig) (*[32]byte, error) {
+	var encoded string
+	if cfg.KeyFile != "" {
+		raw, err := ioutil.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(raw)
+	} else {
+		keyEnv := cfg.KeyEnv
+		if keyEnv == "" {
+			keyEnv = defaultSecretsKeyEnv
+		}
+		encoded = os.Getenv(keyEnv)
+		if encoded == "" {
+			return nil, errors.New("no secrets key given, set " + keyEnv + " or configure secretsFile.keyFile")
+		}
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("the secrets key must be 32 bytes, base64 encoded")
+	}
+
+	key := &[32]byte{}
+	copy(key[:], raw)
+	return key, nil
+}
+
+// readSecretsFile decrypts the secrets file, which holds a random nonce
+// followed by the NaCl secretbox of its JSON content, base64 encoded
+func readSecretsFile(path string, key *[32]byte) (*secretsFile, error) {
+	encoded, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || len(sealed) < 24 {
+		return nil, errors.New("malformed secrets file \"" + path + "\"")
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+	plain, ok := secretbox.Open(nil, sealed[24:], &nonce, key)
+	if !ok {
+		return nil, errors.New("failed to decrypt secrets file \"" + path + "\", is the key correct?")
+	}
+
+	secrets := &secretsFile{}
+	if err := json.Unmarshal(plain, secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// writeSecretsFile encrypts and writes the secrets file with a fresh nonce
+func writeSecretsFile(path string, key *[32]byte, secrets *secretsFile) error {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+
+	sealed := secretbox.Seal(nonce[:], plain, &nonce, key)
+	encoded := base64.StdEncoding.EncodeToString(sealed) + "\n"
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(encoded), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadBuildSecrets decrypts the secrets file at startup and registers every
+// value for masking. A missing file is only an error if one was configured.
+func loadBuildSecrets(config *Configuration) error {
+	path := secretsPath(config.SecretsFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) && config.SecretsFile.Path == "" {
+		return nil
+	}
+
+	key, err := readSecretsKey(config.SecretsFile)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := readSecretsFile(path, key)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, value := range secrets.Global {
+		addSecret(value)
+		count++
+	}
+	for _, project := range secrets.Projects {
+		for _, value := range project {
+			addSecret(value)
+			count++
+		}
+	}
+
+	Log.Infof("Loaded %d secrets from \"%s\"", count, path)
+	buildSecrets = secrets
+	return nil
+}
@@ -0,0 +1,372 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// shellhooks - Shell commands run at points of the build, from the configuration
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/Danw33/go-build/pluginapi"
+)
+
+const (
+	// shellHooksName is the name the shell hooks are dispatched under, as a plugin
+	shellHooksName = "hooks"
+
+	// hookDefaultTimeout is how long a hook command has to finish, unless a
+	// timeout is configured
+	hookDefaultTimeout = 5 * time.Minute
+
+	// onFailureHook is the point at which commands are run when a branch,
+	// project or the run fails
+	onFailureHook = "onFailure"
+)
+
+// hookPoints are the points of the build hook commands can be run at, by
+// their name in the configuration and the plugin hook they are run from
+var hookPoints = map[string]string{
+	"postLoadPlugins":      "PostLoadPlugins",
+	"preProcessProjects":   "PreProcessProjects",
+	"postProcessProjects":  "PostProcessProjects",
+	"preProcessProject":    "PreProcessProject",
+	"postProcessProject":   "PostProcessProject",
+	"preProcessBranch":     "PreProcessBranch",
+	"postProcessBranch":    "PostProcessBranch",
+	"preScript":            "PreScript",
+	"postScript":           "PostScript",
+	"preProcessArtifacts":  "PreProcessArtifacts",
+	"postProcessArtifacts": "PostProcessArtifacts",
+	onFailureHook:          "",
+}
+
+// hookErrorPolicies are the values of onError, and the severity of the error
+// a failed command gives
+var hookErrorPolicies = map[string]func(error) error{
+	"":       pluginapi.Warn,
+	"warn":   pluginapi.Warn,
+	"ignore": nil,
+	"skip":   skipHook,
+	"fail":   pluginapi.FailBranch,
+	"abort":  pluginapi.AbortRun,
+}
+
+// shellHooks runs the hook commands of the global configuration and of each
+// project. They are dispatched to like a plugin, after the plugin files, so
+// their failures are handled as hook errors
+type shellHooks struct {
+	hooks map[string][]HookConfig
+}
+
+// hookPayload is written to the standard input of each hook command
+type hookPayload struct {
+	Hook string `json:"hook"`
+	rpcParams
+}
+
+// loadShellHooks adds the shell hooks to the plugins, if the global
+// configuration or that of any project has hook commands
+func loadShellHooks(config *Configuration) {
+	plog := projectLog("").withPhase("plugin")
+
+	found := checkHooks(config.Hooks, "hooks")
+	for i, proj := range config.Projects {
+		found = append(found, checkHooks(proj.Hooks, fmt.Sprintf("projects[%d].hooks", i))...)
+	}
+	for _, problem := range found {
+		plog.Errorf("%s", problem)
+	}
+
+	if !hasHooks(config) {
+		return
+	}
+
+	pluginsMu.Lock()
+	buildPlugins = append(buildPlugins, &shellHooks{hooks: config.Hooks})
+	buildPluginNames = append(buildPluginNames, shellHooksName)
+	buildPluginFiles = append(buildPluginFiles, "")
+	buildPluginLocks = append(buildPluginLocks, nil)
+	globalPlugins = len(buildPlugins)
+	pluginsMu.Unlock()
+
+	plog.Infof("Hook commands configured for %d points of the build", len(config.Hooks))
+}
+
+// hasHooks returns whether the global configuration or that of any project has
+// hook commands
+func hasHooks(config *Configuration) bool {
+	if len(config.Hooks) > 0 {
+		return true
+	}
+	for _, proj := range config.Projects {
+		if len(proj.Hooks) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHooks returns the problems with a hooks block: unknown points of the
+// build, missing commands, and unknown onError values
+func checkHooks(hooks map[string][]HookConfig, where string) []string {
+	var found []string
+	for point, commands := range hooks {
+		if _, ok := hookPoints[point]; !ok {
+			found = append(found, fmt.Sprintf("%s: \"%s\" is not a point of the build hooks can be run at", where, point))
+		}
+		for i, hc := range commands {
+			hwhere := fmt.Sprintf("%s.%s[%d]", where, point, i)
+			if hc.Command == "" {
+				found = append(found, fmt.Sprintf("%s: no command is given", hwhere))
+			}
+			if _, ok := hookErrorPolicies[hc.OnError]; !ok {
+				found = append(found, fmt.Sprintf("%s: onError \"%s\" is not one of warn, ignore, skip, fail or abort", hwhere, hc.OnError))
+			}
+		}
+	}
+	return found
+}
+
+func (h *shellHooks) PluginInit(ctx context.Context, config []byte) error {
+	return nil
+}
+
+// ConcurrentSafe is true, as each hook command runs in its own process
+func (h *shellHooks) ConcurrentSafe() bool {
+	return true
+}
+
+// masksSecrets marks the hooks as masking the contexts they send, as they
+// need the real env of a project to run their commands with
+func (h *shellHooks) masksSecrets() {}
+
+// runAll runs the commands of the global configuration, then those of the
+// project if any, for a point of the build, returning the worst error
+func (h *shellHooks) runAll(point string, proj *ProjectConfig, params rpcParams) error {
+	commands := h.hooks[point]
+	if proj != nil {
+		commands = append(commands[:len(commands):len(commands)], proj.Hooks[point]...)
+	}
+
+	var worst error
+	for _, hc := range commands {
+		worst = worseError(worst, runHookCommand(point, hc, proj, params))
+	}
+	return worst
+}
+
+// runPost runs the commands for a post-processing point of the build, then the
+// onFailure commands if the work or any of those commands failed
+func (h *shellHooks) runPost(point string, proj *ProjectConfig, params rpcParams, result *pluginapi.Result) error {
+	params.Result = newRPCResult(result)
+	err := h.runAll(point, proj, params)
+
+	if result.Status == pluginapi.StatusFailed || pluginapi.SeverityOf(err) > pluginapi.SeverityWarn {
+		if result.Status != pluginapi.StatusFailed {
+			params.Result = newRPCResult(&pluginapi.Result{Status: pluginapi.StatusFailed, Err: err, Duration: result.Duration})
+		}
+		err = worseError(err, h.runAll(onFailureHook, proj, params))
+	}
+	return err
+}
+
+func (h *shellHooks) PostLoadPlugins(run *pluginapi.RunContext) error {
+	return h.runAll("postLoadPlugins", nil, rpcParams{Run: run})
+}
+
+func (h *shellHooks) PreProcessProjects(run *pluginapi.RunContext) error {
+	return h.runAll("preProcessProjects", nil, rpcParams{Run: run})
+}
+
+func (h *shellHooks) PostProcessProjects(run *pluginapi.RunContext, result *pluginapi.Result) error {
+	return h.runPost("postProcessProjects", nil, rpcParams{Run: run}, result)
+}
+
+func (h *shellHooks) PreProcessProject(pc *pluginapi.ProjectContext) error {
+	return h.runAll("preProcessProject", pc.Project, projectParams(pc))
+}
+
+func (h *shellHooks) PostProcessProject(pc *pluginapi.ProjectContext, result *pluginapi.Result) error {
+	return h.runPost("postProcessProject", pc.Project, projectParams(pc), result)
+}
+
+func (h *shellHooks) PreProcessBranch(bc *pluginapi.BranchContext) error {
+	return h.runAll("preProcessBranch", bc.Project, branchParams(bc))
+}
+
+func (h *shellHooks) PostProcessBranch(bc *pluginapi.BranchContext, result *pluginapi.Result) error {
+	return h.runPost("postProcessBranch", bc.Project, branchParams(bc), result)
+}
+
+func (h *shellHooks) PreScript(sc *pluginapi.ScriptContext) error {
+	return h.runAll("preScript", sc.Project, scriptParams(sc))
+}
+
+func (h *shellHooks) PostScript(sc *pluginapi.ScriptContext, result *pluginapi.Result) error {
+	params := scriptParams(sc)
+	params.Result = newRPCResult(result)
+	return h.runAll("postScript", sc.Project, params)
+}
+
+func (h *shellHooks) PreProcessArtifacts(ac *pluginapi.ArtifactsContext) error {
+	return h.runAll("preProcessArtifacts", ac.Project, artifactsParams(ac))
+}
+
+func (h *shellHooks) PostProcessArtifacts(ac *pluginapi.ArtifactsContext, result *pluginapi.Result) error {
+	params := artifactsParams(ac)
+	params.Result = newRPCResult(result)
+	return h.runAll("postProcessArtifacts", ac.Project, params)
+}
+
+// runHookCommand runs a hook command through the shell, in the directory of
+// the project if it has one. The command receives the parameters of the hook
+// as GO_BUILD_ environment variables, along with the env of the project, and
+// as JSON on its standard input; its output is logged
+func runHookCommand(point string, hc HookConfig, proj *ProjectConfig, params rpcParams) error {
+	plog := projectLog("").withPhase("plugin")
+	if proj != nil {
+		plog = projectLog(proj.Path).withPhase("plugin")
+	}
+	if params.Branch != nil {
+		plog = plog.withBranch(params.Branch.Name)
+	}
+
+	timeout := hookDefaultTimeout
+	if hc.Timeout > 0 {
+		timeout = time.Duration(hc.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(runContext.Context, timeout)
+	defer cancel()
+
+	payload, err := json.Marshal(hookPayload{Hook: point, rpcParams: params})
+	if err != nil {
+		return err
+	}
+
+	cmd := shellCommand(ctx, hc.Command)
+	cmd.Dir = pwd
+	if params.Dir != "" {
+		if _, err := os.Stat(params.Dir); err == nil {
+			cmd.Dir = params.Dir
+		}
+	}
+	cmd.Env = append(os.Environ(), hookEnv(point, params)...)
+	if proj != nil {
+		cmd.Env = append(cmd.Env, projectEnv(*proj)...)
+	}
+	output := &lineLogger{log: plog, prefix: point + ": "}
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	hStart := time.Now()
+	plog.Debugf("running %s hook \"%s\"\n", point, maskSecrets(hc.Command))
+	err = cmd.Run()
+	output.Flush()
+	if err == exec.ErrWaitDelay {
+		// The command succeeded, but left something running that holds its output
+		err = nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err == nil {
+		plog.withDuration(time.Since(hStart)).Debugf("%s hook \"%s\" completed in: %s\n", point, maskSecrets(hc.Command), time.Since(hStart))
+		return nil
+	}
+
+	err = fmt.Errorf("%s hook \"%s\" failed: %v", point, maskSecrets(hc.Command), err)
+	severity, ok := hookErrorPolicies[hc.OnError]
+	if !ok {
+		severity = pluginapi.Warn
+	}
+	if severity == nil {
+		plog.Infof("%v (ignored)\n", err)
+		return nil
+	}
+	return severity(err)
+}
+
+// skipHook returns the decision to skip the work a failed hook command was run
+// before, giving its failure as the reason
+func skipHook(err error) error {
+	return pluginapi.Skip(err.Error())
+}
+
+// hookEnv returns the environment variables giving the parameters of a hook
+func hookEnv(point string, params rpcParams) []string {
+	env := []string{
+		"GO_BUILD_HOOK=" + point,
+		"GO_BUILD_RUN_ID=" + params.Run.ID,
+		"GO_BUILD_HOME=" + params.Run.HomeDir,
+	}
+	if params.Project != nil {
+		env = append(env, "GO_BUILD_PROJECT="+params.Project.Path, "GO_BUILD_PROJECT_DIR="+params.Dir)
+	}
+	if params.Branch != nil {
+		env = append(env, "GO_BUILD_BRANCH="+params.Branch.Name, "GO_BUILD_COMMIT="+params.Branch.Commit)
+	}
+	if params.Script != nil {
+		env = append(env,
+			"GO_BUILD_SCRIPT_INDEX="+strconv.Itoa(params.Script.Index),
+			"GO_BUILD_SCRIPT="+params.Script.Command,
+			"GO_BUILD_STDOUT_LOG="+params.Script.StdoutLog,
+			"GO_BUILD_STDERR_LOG="+params.Script.StderrLog)
+		if params.Result != nil {
+			env = append(env, "GO_BUILD_EXIT_CODE="+strconv.Itoa(params.Script.ExitCode))
+		}
+	}
+	if params.Artifacts != nil {
+		env = append(env, "GO_BUILD_ARTIFACTS="+params.Artifacts.Path, "GO_BUILD_ARTIFACTS_URL="+params.Artifacts.URL)
+	}
+	if params.Result != nil {
+		env = append(env,
+			"GO_BUILD_STATUS="+string(params.Result.Status),
+			"GO_BUILD_ERROR="+params.Result.Error,
+			"GO_BUILD_DURATION="+strconv.FormatFloat(params.Result.Duration, 'f', 3, 64))
+	}
+	return env
+}
+
+// shellCommand returns the command running a command line through the shell.
+// Once the shell exits or is killed, whatever it started is not waited on
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.WaitDelay = time.Second
+	return cmd
+}
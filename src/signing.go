@@ -0,0 +1,328 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// signing - Minisign-compatible detached signatures for published artifacts
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// signatureSuffix is appended to the name of a file to give its detached signature
+const signatureSuffix = ".minisig"
+
+// minisign algorithm identifiers, see https://jedisct1.github.io/minisign/
+var (
+	minisignAlgLegacy   = []byte("Ed")
+	minisignAlgHashed   = []byte("ED")
+	minisignKdfNone     = []byte{0, 0}
+	minisignKdfScrypt   = []byte("Sc")
+	minisignChecksumAlg = []byte("B2")
+)
+
+// signingKey is the loaded secret key used to sign artifacts, or nil when
+// signing is not configured
+var signingKey *minisignSecretKey
+
+type minisignSecretKey struct {
+	keyID      [8]byte
+	privateKey ed25519.PrivateKey
+}
+
+type minisignPublicKey struct {
+	keyID     [8]byte
+	publicKey ed25519.PublicKey
+}
+
+// keyIDString formats a key ID the same way the minisign tool does
+func keyIDString(keyID [8]byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(keyID[:]))
+}
+
+// loadSigningKey reads the configured minisign secret key, decrypting it with
+// the password from the configured environment variable if required
+func loadSigningKey(config *Configuration) error {
+	if config.Signing.Key == "" {
+		return nil
+	}
+
+	password := ""
+	if config.Signing.PasswordEnv != "" {
+		password = os.Getenv(config.Signing.PasswordEnv)
+	}
+
+	key, err := readMinisignSecretKey(config.Signing.Key, password)
+	if err != nil {
+		return err
+	}
+
+	Log.Infof("Artifacts will be signed with key ID %s", keyIDString(key.keyID))
+	signingKey = key
+	return nil
+}
+
+func readMinisignSecretKey(path string, password string) (*minisignSecretKey, error) {
+	raw, err := readMinisignKeyLine(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// sig_alg(2) kdf_alg(2) cksum_alg(2) kdf_salt(32) opslimit(8) memlimit(8) key_id(8) secret_key(64) checksum(32)
+	if len(raw) != 158 || !bytes.Equal(raw[0:2], minisignAlgLegacy) || !bytes.Equal(raw[4:6], minisignChecksumAlg) {
+		return nil, errors.New("unsupported or malformed minisign secret key in \"" + path + "\"")
+	}
+
+	keynum := raw[54:158]
+	switch {
+	case bytes.Equal(raw[2:4], minisignKdfNone):
+	case bytes.Equal(raw[2:4], minisignKdfScrypt):
+		if password == "" {
+			return nil, errors.New("minisign secret key in \"" + path + "\" is encrypted, but no password was given")
+		}
+
+		opsLimit := binary.LittleEndian.Uint64(raw[38:46])
+		memLimit := binary.LittleEndian.Uint64(raw[46:54])
+		n, r, p := scryptParams(opsLimit, memLimit)
+
+		stream, err := scrypt.Key([]byte(password), raw[6:38], n, r, p, len(keynum))
+		if err != nil {
+			return nil, err
+		}
+
+		decrypted := make([]byte, len(keynum))
+		for i := range keynum {
+			decrypted[i] = keynum[i] ^ stream[i]
+		}
+		keynum = decrypted
+	default:
+		return nil, errors.New("unsupported key derivation in minisign secret key \"" + path + "\"")
+	}
+
+	// The checksum covers the algorithm, key ID and secret key
+	checksum := blake2b.Sum256(append(append(append([]byte{}, raw[0:2]...), keynum[0:8]...), keynum[8:72]...))
+	if subtle.ConstantTimeCompare(checksum[:], keynum[72:104]) != 1 {
+		return nil, errors.New("wrong password or corrupt minisign secret key in \"" + path + "\"")
+	}
+
+	key := &minisignSecretKey{privateKey: ed25519.PrivateKey(append([]byte{}, keynum[8:72]...))}
+	copy(key.keyID[:], keynum[0:8])
+	return key, nil
+}
+
+// scryptParams derives the scrypt cost parameters from libsodium's opslimit
+// and memlimit values, following crypto_pwhash_scryptsalsa208sha256
+func scryptParams(opsLimit uint64, memLimit uint64) (int, int, int) {
+	if opsLimit < 32768 {
+		opsLimit = 32768
+	}
+
+	r := uint64(8)
+	p := uint64(1)
+	var maxN uint64
+	if opsLimit < memLimit/32 {
+		maxN = opsLimit / (r * 4)
+	} else {
+		maxN = memLimit / (r * 128)
+	}
+
+	nLog2 := uint(1)
+	for ; nLog2 < 63; nLog2++ {
+		if uint64(1)<<nLog2 > maxN/2 {
+			break
+		}
+	}
+
+	if opsLimit >= memLimit/32 {
+		maxRP := (opsLimit / 4) / (uint64(1) << nLog2)
+		if maxRP > 0x3fffffff {
+			maxRP = 0x3fffffff
+		}
+		p = maxRP / r
+	}
+
+	return 1 << nLog2, int(r), int(p)
+}
+
+// readMinisignPublicKey reads a minisign public key, either from a key file or
+// given directly as its base64 encoding
+func readMinisignPublicKey(pathOrKey string) (*minisignPublicKey, error) {
+	var raw []byte
+	var err error
+
+	if _, statErr := os.Stat(pathOrKey); statErr == nil {
+		raw, err = readMinisignKeyLine(pathOrKey)
+	} else {
+		raw, err = base64.StdEncoding.DecodeString(pathOrKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) != 42 || !bytes.Equal(raw[0:2], minisignAlgLegacy) {
+		return nil, errors.New("unsupported or malformed minisign public key")
+	}
+
+	key := &minisignPublicKey{publicKey: ed25519.PublicKey(append([]byte{}, raw[10:42]...))}
+	copy(key.keyID[:], raw[2:10])
+	return key, nil
+}
+
+// readMinisignKeyLine returns the decoded key from a minisign key file, which
+// is the first line that isn't a comment
+func readMinisignKeyLine(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		return base64.StdEncoding.DecodeString(line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no key found in \"" + path + "\"")
+}
+
+// hashForSignature returns the BLAKE2b-512 digest of a file, which is what a
+// prehashed ("ED") minisign signature covers
+func hashForSignature(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash, err := blake2b.New512(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+
+	return hash.Sum(nil), nil
+}
+
+// signFile writes a detached minisign signature for path to path.minisig
+func signFile(key *minisignSecretKey, path string) error {
+	digest, err := hashForSignature(path)
+	if err != nil {
+		return err
+	}
+
+	signature := ed25519.Sign(key.privateKey, digest)
+	trustedComment := "timestamp:" + strconv.FormatInt(time.Now().Unix(), 10) + "\tfile:" + filepath.Base(path) + "\thashed"
+	globalSignature := ed25519.Sign(key.privateKey, append(append([]byte{}, signature...), trustedComment...))
+
+	sigBlob := append(append(append([]byte{}, minisignAlgHashed...), key.keyID[:]...), signature...)
+
+	out := "untrusted comment: signature from go-build secret key " + keyIDString(key.keyID) + "\n" +
+		base64.StdEncoding.EncodeToString(sigBlob) + "\n" +
+		"trusted comment: " + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSignature) + "\n"
+
+	return writeFileAtomic(path+signatureSuffix, []byte(out))
+}
+
+// verifyFileSignature checks the detached minisign signature of path against
+// the given public key
+func verifyFileSignature(key *minisignPublicKey, path string) error {
+	sigData, err := ioutil.ReadFile(path + signatureSuffix)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(sigData), "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("malformed signature file \"" + path + signatureSuffix + "\"")
+	}
+
+	sigBlob, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sigBlob) != 74 {
+		return errors.New("malformed signature in \"" + path + signatureSuffix + "\"")
+	}
+
+	if !bytes.Equal(sigBlob[2:10], key.keyID[:]) {
+		var sigKeyID [8]byte
+		copy(sigKeyID[:], sigBlob[2:10])
+		return errors.New("signature was made with key ID " + keyIDString(sigKeyID) + ", not " + keyIDString(key.keyID))
+	}
+
+	var message []byte
+	switch {
+	case bytes.Equal(sigBlob[0:2], minisignAlgHashed):
+		if message, err = hashForSignature(path); err != nil {
+			return err
+		}
+	case bytes.Equal(sigBlob[0:2], minisignAlgLegacy):
+		if message, err = ioutil.ReadFile(path); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unsupported signature algorithm in \"" + path + signatureSuffix + "\"")
+	}
+
+	signature := sigBlob[10:74]
+	if !ed25519.Verify(key.publicKey, message, signature) {
+		return errors.New("signature verification failed for \"" + path + "\"")
+	}
+
+	globalSignature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return errors.New("malformed trusted comment signature in \"" + path + signatureSuffix + "\"")
+	}
+
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(key.publicKey, append(append([]byte{}, signature...), trustedComment...), globalSignature) {
+		return errors.New("trusted comment verification failed for \"" + path + "\"")
+	}
+
+	return nil
+}
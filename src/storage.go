@@ -0,0 +1,311 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// storage - Artifact storage backends
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ArtifactStorage is where the published artifacts of each branch build end up.
+// Keys are slash-separated paths such as "artifacts/<project>/<branch>".
+type ArtifactStorage interface {
+	// Publish replaces everything stored under key with the contents of localDir,
+	// which may be consumed in the process, logging its progress to plog. It
+	// stops early with the error of ctx if ctx is cancelled
+	Publish(ctx context.Context, localDir string, key string, plog fieldLogger) error
+
+	// Describe returns a human-readable location for key, for logging
+	Describe(key string) string
+}
+
+// artifactStorage is the storage backend configured for this run
+var artifactStorage ArtifactStorage
+
+// publicBaseURL is the configured public URL under which published keys can be
+// reached, without a trailing slash
+var publicBaseURL string
+
+// artifactsKey is the storage key for the unpacked artifacts of a branch build
+func artifactsKey(project string, branchName string) string {
+	return "artifacts/" + project + "/" + branchName
+}
+
+// archivesKey is the storage key for the archives of a branch build
+func archivesKey(project string, branchName string) string {
+	return "archives/" + project + "/" + branchName
+}
+
+// publicURL returns the public URL of a storage key, or an empty string when no
+// public base URL has been configured
+func publicURL(key string) string {
+	if publicBaseURL == "" {
+		return ""
+	}
+	return publicBaseURL + "/" + key
+}
+
+// configureStorage sets up the artifact storage backend from the configuration
+func configureStorage(config *Configuration) error {
+	publicBaseURL = strings.TrimSuffix(config.Storage.PublicURL, "/")
+
+	switch config.Storage.Type {
+	case "", "local":
+		root := config.Storage.Local.Path
+		if root == "" {
+			root = config.Home
+		}
+		Log.Infof("Artifacts will be published to local storage in \"%s\"", root)
+		local := &localStorage{root: root}
+		if config.Storage.Local.Dedup {
+			local.blobs = blobStoreDirectory(config.Home)
+			Log.Infof("Published files will be deduplicated into the blob store in \"%s\"", local.blobs)
+		}
+		artifactStorage = local
+	case "s3":
+		s3, err := newS3Storage(config.Storage.S3)
+		if err != nil {
+			return err
+		}
+		Log.Infof("Artifacts will be published to S3 bucket \"%s\" at %s", config.Storage.S3.Bucket, config.Storage.S3.Endpoint)
+		artifactStorage = s3
+	default:
+		return errors.New("unknown storage type \"" + config.Storage.Type + "\"")
+	}
+
+	return nil
+}
+
+// localStorage publishes artifacts into a directory on the local filesystem,
+// optionally hardlinking identical files to a shared blob store
+type localStorage struct {
+	root  string
+	blobs string
+}
+
+func (s *localStorage) Describe(key string) string {
+	return "\"" + s.root + "/" + key + "\""
+}
+
+func (s *localStorage) Publish(ctx context.Context, localDir string, key string, plog fieldLogger) error {
+	destination := s.root + "/" + key
+
+	if err := os.RemoveAll(destination); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+
+	// A rename is all that's needed on the same filesystem, otherwise fall back to copying
+	if err := os.Rename(localDir, destination); err != nil {
+		if err := copyTree(localDir, destination); err != nil {
+			return err
+		}
+	}
+
+	if s.blobs == "" || ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	linked, saved, err := dedupTree(destination, s.blobs)
+	if err != nil {
+		return err
+	}
+	plog.Debugf("deduplicated %d files in \"%s\", saving %d bytes\n", linked, key, saved)
+
+	return nil
+}
+
+// copyTree copies the directory src to dst, preserving file modes and symlinks
+func copyTree(src string, dst string) error {
+	return filepath.Walk(src, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(dstPath, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(srcPath)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, dstPath)
+		}
+
+		in, err := os.Open(srcPath)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// s3Storage publishes artifacts to a bucket on any S3-compatible service
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3Storage(cfg S3StorageConfig) (*s3Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 storage requires an endpoint and a bucket")
+	}
+
+	// Credentials come from the environment, never from the configuration file
+	creds := credentials.NewEnvAWS()
+	if cfg.AccessKeyEnv != "" || cfg.SecretKeyEnv != "" {
+		creds = credentials.NewStaticV4(os.Getenv(cfg.AccessKeyEnv), os.Getenv(cfg.SecretKeyEnv), "")
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &s3Storage{client: client, bucket: cfg.Bucket, prefix: prefix}, nil
+}
+
+func (s *s3Storage) Describe(key string) string {
+	return "s3://" + s.bucket + "/" + s.prefix + key
+}
+
+func (s *s3Storage) Publish(ctx context.Context, localDir string, key string, plog fieldLogger) error {
+	keyPrefix := s.prefix + key + "/"
+	uploaded := make(map[string]bool)
+
+	err := filepath.Walk(localDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(localDir, filePath)
+		if err != nil {
+			return err
+		}
+		objectName := keyPrefix + filepath.ToSlash(rel)
+
+		contentType, err := detectContentType(filePath)
+		if err != nil {
+			return err
+		}
+
+		plog.Debugf("uploading \"%s\" (%s)\n", objectName, contentType)
+		_, err = s.client.FPutObject(ctx, s.bucket, objectName, filePath, minio.PutObjectOptions{ContentType: contentType})
+		if err != nil {
+			return err
+		}
+
+		uploaded[objectName] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Anything left under the key from a previous build is stale
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: keyPrefix, Recursive: true}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		if uploaded[object.Key] {
+			continue
+		}
+
+		plog.Debugf("removing stale object \"%s\"\n", object.Key)
+		if err := s.client.RemoveObject(ctx, s.bucket, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// detectContentType guesses the content type of a file from its extension,
+// falling back to sniffing its first 512 bytes
+func detectContentType(filePath string) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(filePath)); contentType != "" {
+		return contentType, nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	return http.DetectContentType(head[:n]), nil
+}
@@ -0,0 +1,197 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// stream - Line-by-line streaming of script output
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxLineLength is the longest line held in memory, longer lines are split
+	maxLineLength = 64 * 1024
+
+	// tailLines is how many of the most recent lines are kept for error reports
+	tailLines = 20
+
+	colorReset  = "\033[0m"
+	colorPrefix = "\033[36m"
+	colorStderr = "\033[31m"
+)
+
+// consoleLock serialises echoed lines, as scripts of several projects may be
+// running at once in async mode
+var consoleLock sync.Mutex
+
+// consoleColor is whether echoed output is colored, only done on a terminal
+var consoleColor = isTerminal(consoleOut)
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// logStream receives the output of one stream of a script, writing it to its
+// log file line by line as it arrives, and optionally echoing it to the console
+type logStream struct {
+	file     *os.File
+	name     string
+	fields   logFields
+	echo     bool
+	combined *combinedLog
+	maxSize  int64
+	written  int64
+	partial  bytes.Buffer
+	tail     []string
+	dropped  bool
+	writeErr error
+}
+
+// newLogStream creates the log file for a script stream. fields identify the
+// project, branch and script on the console, and name is the stream, "stdout" or "stderr".
+// Each line is also added to the combined log of the branch, if one is given.
+func newLogStream(path string, fields logFields, name string, combined *combinedLog, cfg LogConfig) (*logStream, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logStream{
+		file:     file,
+		name:     name,
+		fields:   fields,
+		echo:     cfg.Echo,
+		combined: combined,
+		maxSize:  cfg.MaxSize,
+	}, nil
+}
+
+// Write splits the output into lines, handling each complete line and holding
+// back any partial line until the rest of it arrives
+func (s *logStream) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			s.partial.Write(p)
+			if s.partial.Len() >= maxLineLength {
+				s.line(s.partial.String())
+				s.partial.Reset()
+			}
+			break
+		}
+
+		s.partial.Write(p[:i])
+		s.line(s.partial.String())
+		s.partial.Reset()
+		p = p[i+1:]
+	}
+
+	return n, nil
+}
+
+// line handles a single complete line of output
+func (s *logStream) line(text string) {
+	text = maskSecrets(strings.TrimSuffix(text, "\r"))
+
+	s.tail = append(s.tail, text)
+	if len(s.tail) > tailLines {
+		s.tail = s.tail[1:]
+	}
+
+	s.writeLog(text + "\n")
+	s.combined.Line(s.name, text)
+
+	if s.echo {
+		s.echoLine(text)
+	}
+}
+
+// writeLog appends to the log file, until it reaches the configured maximum size
+func (s *logStream) writeLog(text string) {
+	if s.dropped || s.writeErr != nil {
+		return
+	}
+
+	if s.maxSize > 0 && s.written+int64(len(text)) > s.maxSize {
+		s.dropped = true
+		text = fmt.Sprintf("[go-build: log truncated, it exceeded the maximum size of %d bytes]\n", s.maxSize)
+	}
+
+	n, err := s.file.WriteString(text)
+	s.written += int64(n)
+	s.writeErr = err
+}
+
+func (s *logStream) echoLine(text string) {
+	if jsonLogging {
+		rec := newJSONRecord(time.Now(), "INFO", s.fields, text)
+		rec.Stream = s.name
+		jsonConsole.write(rec)
+		return
+	}
+
+	stamp := time.Now().Format("15:04:05.000")
+	prefix := s.fields.Project + "/" + s.fields.Branch
+
+	consoleLock.Lock()
+	defer consoleLock.Unlock()
+
+	if !consoleColor {
+		fmt.Fprintf(consoleOut, "%s [%s] %s\n", stamp, prefix, text)
+		return
+	}
+
+	if s.name == "stderr" {
+		fmt.Fprintf(consoleOut, "%s%s [%s]%s %s%s%s\n", colorPrefix, stamp, prefix, colorReset, colorStderr, text, colorReset)
+		return
+	}
+	fmt.Fprintf(consoleOut, "%s%s [%s]%s %s\n", colorPrefix, stamp, prefix, colorReset, text)
+}
+
+// Tail returns the most recent lines of output
+func (s *logStream) Tail() string {
+	return strings.Join(s.tail, "\n")
+}
+
+// Close flushes any partial last line and closes the log file
+func (s *logStream) Close() error {
+	if s.partial.Len() > 0 {
+		s.line(s.partial.String())
+		s.partial.Reset()
+	}
+
+	if err := s.file.Close(); s.writeErr == nil {
+		s.writeErr = err
+	}
+	return s.writeErr
+}
@@ -0,0 +1,224 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// templates - Variables and functions of the templated configuration fields
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/Danw33/go-build/pluginapi"
+)
+
+// templateValuesHook is the name TemplateValues calls are dispatched and traced as
+const templateValuesHook = "TemplateValues"
+
+// builtinTemplateFuncs are the functions predefined by text/template, which
+// plugins can't replace
+var builtinTemplateFuncs = []string{"and", "call", "html", "index", "slice", "js", "len", "not", "or", "print", "printf", "println", "urlquery", "eq", "ge", "gt", "le", "lt", "ne"}
+
+// templateNamePattern matches the names a variable can be used by in a template
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// templatesMu guards the template variables and functions of plugins, which
+// plugins listed only by projects register while projects are processed
+var templatesMu sync.RWMutex
+
+// templateVariableOwners contains the name of the plugin adding each template variable
+var templateVariableOwners = map[string]string{}
+
+// templateFuncOwners contains the name of the plugin adding each template function
+var templateFuncOwners = map[string]string{}
+
+// templateFuncs contains the template functions added by plugins
+var templateFuncs = template.FuncMap{}
+
+// coreTemplateVariables returns the names of the template variables go-build
+// provides itself, in scripts and archive names
+func coreTemplateVariables() []string {
+	var names []string
+	for _, t := range []reflect.Type{reflect.TypeOf(scriptVariables{}), reflect.TypeOf(archiveVariables{})} {
+		for i := 0; i < t.NumField(); i++ {
+			names = append(names, t.Field(i).Name)
+		}
+	}
+	return names
+}
+
+// registerTemplates adds the template variables and functions of a plugin once
+// it is initialised, failing if any of their names is already taken or a
+// function can't be used in a template
+func registerTemplates(name string, bp pluginapi.Plugin) error {
+	var variables []string
+	if tv, ok := bp.(pluginapi.TemplateVariables); ok {
+		variables = tv.TemplateVariables()
+	}
+	var funcs map[string]interface{}
+	if tf, ok := bp.(pluginapi.TemplateFuncs); ok {
+		funcs = tf.TemplateFuncs()
+	}
+	if len(variables) == 0 && len(funcs) == 0 {
+		return nil
+	}
+
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+
+	taken := map[string]string{}
+	for _, v := range coreTemplateVariables() {
+		taken[v] = "go-build"
+	}
+	for v, owner := range templateVariableOwners {
+		taken[v] = fmt.Sprintf("plugin \"%s\"", owner)
+	}
+	for _, v := range variables {
+		if !templateNamePattern.MatchString(v) {
+			return fmt.Errorf("template variable \"%s\" is not a valid name", v)
+		}
+		owner, ok := taken[v]
+		if ok && owner == "" {
+			return fmt.Errorf("template variable \"%s\" is listed twice", v)
+		} else if ok {
+			return fmt.Errorf("template variable \"%s\" is already defined by %s", v, owner)
+		}
+		taken[v] = ""
+	}
+
+	fnames := make([]string, 0, len(funcs))
+	for f := range funcs {
+		fnames = append(fnames, f)
+	}
+	sort.Strings(fnames)
+	for _, f := range fnames {
+		for _, builtin := range builtinTemplateFuncs {
+			if f == builtin {
+				return fmt.Errorf("template function \"%s\" is already defined by text/template", f)
+			}
+		}
+		if owner, ok := templateFuncOwners[f]; ok {
+			return fmt.Errorf("template function \"%s\" is already defined by plugin \"%s\"", f, owner)
+		}
+	}
+	if err := checkTemplateFuncs(funcs); err != nil {
+		return err
+	}
+
+	for _, v := range variables {
+		templateVariableOwners[v] = name
+	}
+	for f, fn := range funcs {
+		templateFuncOwners[f] = name
+		templateFuncs[f] = fn
+	}
+	return nil
+}
+
+// checkTemplateFuncs returns why a set of functions can't be added to a
+// template, as text/template panics on invalid names and functions
+func checkTemplateFuncs(funcs map[string]interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("template functions are invalid: %v", r)
+		}
+	}()
+	template.New("check").Funcs(funcs)
+	return nil
+}
+
+// runTemplateValues collects the values of the template variables added by
+// the plugins active for a branch, once it is checked out
+func runTemplateValues(parent *span, bc *pluginapi.BranchContext) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	err := dispatchHook(parent, projectLog(bc.Project.Path).withBranch(bc.Name), templateValuesHook, activePlugins(bc.Project), func(p pluginapi.Plugin) func() error {
+		if tv, ok := p.(pluginapi.TemplateVariables); ok {
+			return func() error {
+				view, unmask := branchView(p, bc)
+				pv, err := tv.TemplateValues(view)
+				unmask()
+
+				// Only the variables the plugin registered are taken
+				declared := map[string]bool{}
+				for _, v := range tv.TemplateVariables() {
+					declared[v] = true
+				}
+				var undeclared []string
+				for v, value := range pv {
+					if !declared[v] {
+						undeclared = append(undeclared, v)
+						continue
+					}
+					values[v] = value
+				}
+				if err == nil && len(undeclared) > 0 {
+					sort.Strings(undeclared)
+					err = pluginapi.Warn(fmt.Errorf("gave values for template variables it doesn't define: %s", strings.Join(undeclared, ", ")))
+				}
+				return err
+			}
+		}
+		return nil
+	})
+	return values, err
+}
+
+// templateData returns the data a templated field is expanded with: the
+// values of the variables added by plugins, and the fields of the core's
+// variables struct
+func templateData(values map[string]interface{}, core interface{}) map[string]interface{} {
+	data := make(map[string]interface{}, len(values))
+	for v, value := range values {
+		data[v] = value
+	}
+
+	cv := reflect.ValueOf(core)
+	for i := 0; i < cv.NumField(); i++ {
+		data[cv.Type().Field(i).Name] = cv.Field(i).Interface()
+	}
+	return data
+}
+
+// expandTemplate expands a templated field of the configuration with the
+// functions added by plugins. Using a variable that isn't defined is an error
+func expandTemplate(name string, text string, data map[string]interface{}) (string, error) {
+	templatesMu.RLock()
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+	templatesMu.RUnlock()
+	if err != nil {
+		return "", err
+	}
+
+	expanded := &bytes.Buffer{}
+	if err := tmpl.Execute(expanded, data); err != nil {
+		return "", err
+	}
+	return expanded.String(), nil
+}
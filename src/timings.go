@@ -0,0 +1,198 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// timings - Phase timing breakdown per branch and slowest steps of a run
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// timingsFile is the file the timings of every step are written to, in
+	// the log directory of the run
+	timingsFile = "timings.json"
+
+	// timingsHistoryFile is the file the slowest steps of every run are
+	// appended to, in the logs directory
+	timingsHistoryFile = "timings-history.jsonl"
+
+	// slowestSteps is the number of steps listed in the slowest steps table
+	slowestSteps = 10
+)
+
+// stepTiming is how long one step of a build took
+type stepTiming struct {
+	Project  string        `json:"project"`
+	Branch   string        `json:"branch,omitempty"`
+	Step     string        `json:"step"`
+	Duration time.Duration `json:"-"`
+	Seconds  float64       `json:"seconds"`
+}
+
+// runTimings holds the timings of every step of the run
+var runTimings struct {
+	lock  sync.Mutex
+	steps []stepTiming
+}
+
+// stepTimer records the timings of the steps of a project, or of one of its
+// branches. A nil stepTimer records nothing.
+type stepTimer struct {
+	lock    sync.Mutex
+	project string
+	branch  string
+	steps   []stepTiming
+}
+
+// newStepTimer creates a timer for the steps of a project, and of a branch if
+// one is given
+func newStepTimer(project string, branch string) *stepTimer {
+	return &stepTimer{project: project, branch: branch}
+}
+
+// record records the duration of a step
+func (t *stepTimer) record(step string, d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	timing := stepTiming{t.project, t.branch, step, d, d.Seconds()}
+
+	t.lock.Lock()
+	t.steps = append(t.steps, timing)
+	t.lock.Unlock()
+
+	runTimings.lock.Lock()
+	runTimings.steps = append(runTimings.steps, timing)
+	runTimings.lock.Unlock()
+}
+
+// breakdown describes the time taken by each step, in the order they ran
+func (t *stepTimer) breakdown() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var parts []string
+	for _, s := range t.steps {
+		parts = append(parts, fmt.Sprintf("%s %s", s.Step, s.Duration.Round(time.Millisecond)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// slowestRunSteps returns the slowest steps across the run, slowest first
+func slowestRunSteps() []stepTiming {
+	runTimings.lock.Lock()
+	steps := append([]stepTiming(nil), runTimings.steps...)
+	runTimings.lock.Unlock()
+
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].Duration > steps[j].Duration
+	})
+	if len(steps) > slowestSteps {
+		steps = steps[:slowestSteps]
+	}
+	return steps
+}
+
+// logSlowestSteps logs a table of the slowest steps across the run
+func logSlowestSteps(slowest []stepTiming) {
+	if len(slowest) == 0 {
+		return
+	}
+
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  DURATION\tPROJECT\tBRANCH\tSTEP")
+	for _, s := range slowest {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", s.Duration.Round(time.Millisecond), s.Project, s.Branch, s.Step)
+	}
+	w.Flush()
+
+	Log.Infof("Slowest steps of the run:\n%s", strings.TrimRight(table.String(), "\n"))
+}
+
+// runTimingsReport is the content of the timings file of a run
+type runTimingsReport struct {
+	Run     string       `json:"run"`
+	Steps   []stepTiming `json:"steps,omitempty"`
+	Slowest []stepTiming `json:"slowest"`
+}
+
+// writeTimings writes the timings of every step of the run, in the order they
+// finished, and its slowest steps to the log directory of the run. The slowest
+// steps are also appended to the timings history, to compare them across runs
+func writeTimings(home string, slowest []stepTiming) error {
+	runTimings.lock.Lock()
+	report := runTimingsReport{Run: runID, Steps: append([]stepTiming(nil), runTimings.steps...), Slowest: slowest}
+	runTimings.lock.Unlock()
+
+	if len(report.Steps) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := home + "/logs/" + runID
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(dir+"/"+timingsFile, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	return appendTimingsHistory(home, runTimingsReport{Run: runID, Slowest: slowest})
+}
+
+// appendTimingsHistory appends the slowest steps of the run to the timings
+// history, one JSON object per line
+func appendTimingsHistory(home string, entry runTimingsReport) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	history, err := os.OpenFile(home+"/logs/"+timingsHistoryFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := history.Write(append(data, '\n')); err != nil {
+		history.Close()
+		return err
+	}
+	return history.Close()
+}
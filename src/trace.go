@@ -0,0 +1,304 @@
+/**
+go-build - Mulit-Project Build Utility by @Danw33
+MIT License
+
+Copyright 2017 - 2018 Daniel Wilson <hello@danw.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// trace - OpenTelemetry-style tracing of a build run
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTraceEndpoint is the OTLP/HTTP traces endpoint of a local collector
+	defaultTraceEndpoint = "http://localhost:4318/v1/traces"
+
+	// traceFile is the file the trace is written to, in the log directory of
+	// the run, when no other path is configured
+	traceFile = "trace.json"
+)
+
+// tracer collects the finished spans of the run, or is nil when tracing is off
+var tracer *spanCollector
+
+// runSpan is the root span of the run, which project spans are children of
+var runSpan *span
+
+// spanCollector holds finished spans until they are exported at the end of the run
+type spanCollector struct {
+	lock  sync.Mutex
+	cfg   TracingConfig
+	path  string
+	spans []*span
+}
+
+// span is a timed operation within the trace of a run. Spans are only
+// exported when tracing is on, and a nil span is valid too. A span that is a
+// step of a build also records its duration with the step timer it was
+// started with.
+type span struct {
+	lock     sync.Mutex
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	err      string
+	ended    bool
+	timer    *stepTimer
+}
+
+// configureTracing enables tracing if an exporter is configured
+func configureTracing(config *Configuration) error {
+	cfg := config.Tracing
+	switch cfg.Exporter {
+	case "":
+		return nil
+	case "otlp":
+		if cfg.Endpoint == "" {
+			cfg.Endpoint = defaultTraceEndpoint
+		}
+		Log.Infof("Tracing is enabled, exporting to \"%s\"", cfg.Endpoint)
+		tracer = &spanCollector{cfg: cfg}
+	case "file":
+		path := cfg.Path
+		if path == "" {
+			path = config.Home + "/logs/" + runID + "/" + traceFile
+		}
+		Log.Infof("Tracing is enabled, exporting to \"%s\"", path)
+		tracer = &spanCollector{cfg: cfg, path: path}
+	default:
+		return errors.New("unknown tracing exporter \"" + cfg.Exporter + "\", expected otlp or file")
+	}
+
+	runSpan = startSpan(nil, "go-build run")
+	runSpan.SetAttr("run", runID)
+	runSpan.SetAttr("version", Version)
+	return nil
+}
+
+// startSpan starts a span, as a child of parent or as the root of a new trace
+func startSpan(parent *span, name string) *span {
+	s := &span{name: maskSecrets(name), start: time.Now(), attrs: make(map[string]interface{})}
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// startStep starts the span of a build step, which records its duration with
+// timer when it ends
+func startStep(parent *span, timer *stepTimer, name string) *span {
+	s := startSpan(parent, name)
+	s.timer = timer
+	return s
+}
+
+// SetAttr sets an attribute of the span; string values are masked
+func (s *span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	if str, ok := value.(string); ok {
+		value = maskSecrets(str)
+	}
+
+	s.lock.Lock()
+	s.attrs[key] = value
+	s.lock.Unlock()
+}
+
+// End ends the span, marking it as failed if err is not nil
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	if err != nil {
+		s.err = maskSecrets(err.Error())
+	}
+	s.lock.Unlock()
+
+	s.timer.record(s.name, s.end.Sub(s.start))
+
+	if tracer != nil {
+		tracer.lock.Lock()
+		tracer.spans = append(tracer.spans, s)
+		tracer.lock.Unlock()
+	}
+}
+
+// finishTracing ends the run span and exports the trace
+func finishTracing() {
+	if tracer == nil {
+		return
+	}
+
+	runSpan.End(nil)
+
+	tracer.lock.Lock()
+	body, err := json.Marshal(otlpTrace(tracer.spans))
+	tracer.lock.Unlock()
+
+	if err == nil {
+		if tracer.path != "" {
+			err = writeTrace(tracer.path, body)
+		} else {
+			err = postTrace(tracer.cfg, body)
+		}
+	}
+
+	if err != nil {
+		reportError(err)
+		Log.Errorf("Failed to export the trace: %v", err)
+		return
+	}
+	Log.Infof("Exported trace %s", hex.EncodeToString(runSpan.traceID[:]))
+}
+
+// writeTrace writes the trace to a file, creating its directory if needed
+func writeTrace(path string, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, body, 0644)
+}
+
+// postTrace sends the trace to an OTLP/HTTP endpoint
+func postTrace(cfg TracingConfig, body []byte) error {
+	req, err := http.NewRequest("POST", cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("trace export failed with %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// otlpTrace converts the spans into an OTLP/JSON ExportTraceServiceRequest
+func otlpTrace(spans []*span) map[string]interface{} {
+	var otlpSpans []map[string]interface{}
+	for _, s := range spans {
+		s.lock.Lock()
+		otlpSpan := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+			"status":            map[string]interface{}{"code": 1},
+		}
+		if s.parentID != [8]byte{} {
+			otlpSpan["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != "" {
+			otlpSpan["status"] = map[string]interface{}{"code": 2, "message": s.err}
+		}
+		s.lock.Unlock()
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+
+	resource := otlpAttributes(map[string]interface{}{
+		"service.name":    "go-build",
+		"service.version": Version,
+	})
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": resource},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "go-build", "version": Version},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+// otlpAttributes converts attributes into OTLP/JSON key-value pairs
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	res := []interface{}{}
+	for key, value := range attrs {
+		var v map[string]interface{}
+		switch val := value.(type) {
+		case bool:
+			v = map[string]interface{}{"boolValue": val}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(val)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": val}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(val)}
+		}
+		res = append(res, map[string]interface{}{"key": key, "value": v})
+	}
+	return res
+}
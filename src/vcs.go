@@ -31,7 +31,6 @@ import (
 	"strings"
 
 	"github.com/libgit2/git2go"
-	"github.com/getsentry/raven-go"
 )
 
 func configureCloneOpts() *git.CloneOptions {
@@ -67,40 +66,42 @@ func certificateCheckCallback(cert *git.Certificate, valid bool, hostname string
 }
 
 func cloneRepo(twd string, url string, path string, cloneOpts *git.CloneOptions) (*git.Repository, error) {
+	plog := projectLog(path).withPhase("clone")
 
-	Log.Debugf(" [%s] - cloning repository from \"%s\" into \"%s\"\n", path, url, twd)
+	plog.Debugf("cloning repository from \"%s\" into \"%s\"\n", url, twd)
 
 	// Clone
 	repo, err := git.Clone(url, twd, cloneOpts)
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		return nil, err
 	}
 
-	Log.Debugf(" [%s] - clone completed, finding head ref\n", path)
+	plog.Debugf("clone completed, finding head ref\n")
 
 	// Get HEAD ref
 	head, err := repo.Head()
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		return nil, err
 	}
 
-	Log.Debugf(" [%s] - head is now at %v\n", path, head.Target())
+	plog.Debugf("head is now at %v\n", head.Target())
 
 	return repo, nil
 }
 
 func fetchChanges(repo *git.Repository, fallbackURL string, project string) error {
+	plog := projectLog(project).withPhase("fetch")
 
-	Log.Debugf(" [%s] - Looking up remote \"origin\"...", project)
+	plog.Debugf("Looking up remote \"origin\"...")
 
 	remote, err := repo.Remotes.Lookup("origin")
 	if err != nil {
-		Log.Debugf(" [%s] - Remote \"origin\" does not exist, setting it to the configured project URL...", project)
+		plog.Debugf("Remote \"origin\" does not exist, setting it to the configured project URL...")
 		remote, err = repo.Remotes.Create("origin", fallbackURL)
 		if err != nil {
-			raven.CaptureError(err, nil)
+			reportError(err)
 			return err
 		}
 	}
@@ -114,10 +115,10 @@ func fetchChanges(repo *git.Repository, fallbackURL string, project string) erro
 		UpdateFetchhead: true,
 	}
 
-	Log.Debugf(" [%s] - Fetching changes from remote \"origin\"...", project)
+	plog.Debugf("Fetching changes from remote \"origin\"...")
 	err = remote.Fetch([]string{}, fopts, "")
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		return err
 	}
 
@@ -125,22 +126,23 @@ func fetchChanges(repo *git.Repository, fallbackURL string, project string) erro
 }
 
 func pullChanges(repo *git.Repository, project string) error {
+	plog := projectLog(project).withPhase("pull")
 
 	head, headErr := repo.Head()
 	if headErr != nil {
-		Log.Errorf(" [%s] - Error whilst finding current HEAD for repository!", project)
+		plog.Errorf("Error whilst finding current HEAD for repository!")
 		return headErr
 	}
 
 	if head == nil {
-		Log.Errorf(" [%s] - Failed to find current HEAD for repository!", project)
+		plog.Errorf("Failed to find current HEAD for repository!")
 		return errors.New("failed to find current HEAD")
 	}
 
 	// Find the branch name
 	branch := ""
 	hName := head.Name()
-	Log.Debugf(" [%s] - Parsing head name '%s' to determine branch name", project, hName)
+	plog.Debugf("Parsing head name '%s' to determine branch name", hName)
 	branchElements := strings.Split(hName, "/")
 	bECount := len(branchElements)
 	if bECount == 3 {
@@ -149,153 +151,153 @@ func pullChanges(repo *git.Repository, project string) error {
 		branch = strings.Join(branchElements[2:], "/")
 	} else {
 		// Less than 3 or no count
-		Log.Errorf(" [%s] - Failed to determine branch name from repository head!", project)
+		plog.Errorf("Failed to determine branch name from repository head!")
 		return errors.New("invalid quantity of branch elements received for parsing")
 	}
-	Log.Debugf(" [%s] - Got branch name '%s' for head", project, branch)
+	plog.Debugf("Got branch name '%s' for head", branch)
 
 	// Get remote ref for current branch
 	remoteBranch, err := repo.References.Lookup("refs/remotes/origin/" + branch)
 	if err != nil {
-		raven.CaptureError(err, nil)
-		Log.Errorf(" [%s] - Failed to get remote ref for branch '%s' when using 'refs/remotes/origin/%s' for lookup", project, branch, branch)
+		reportError(err)
+		plog.Errorf("Failed to get remote ref for branch '%s' when using 'refs/remotes/origin/%s' for lookup", branch, branch)
 		return err
 	}
-	Log.Debugf(" [%s] - Got remote ref for branch '%s' using 'refs/remotes/origin/%s'", project, branch, branch)
+	plog.Debugf("Got remote ref for branch '%s' using 'refs/remotes/origin/%s'", branch, branch)
 
 	remoteBranchID := remoteBranch.Target()
 	// Get annotated commit
 	annotatedCommit, err := repo.AnnotatedCommitFromRef(remoteBranch)
 	if err != nil {
-		raven.CaptureError(err, nil)
-		Log.Errorf(" [%s] - Failed to get annotated commit from remote branch ref '%s'!", project, remoteBranch)
+		reportError(err)
+		plog.Errorf("Failed to get annotated commit from remote branch ref '%s'!", remoteBranch)
 		return err
 	}
-	Log.Debugf(" [%s] - Got annotated commit from remote ref", project)
+	plog.Debugf("Got annotated commit from remote ref")
 
 	// Do the merge analysis
-	Log.Debugf(" [%s] - Performing merge analysis...", project)
+	plog.Debugf("Performing merge analysis...")
 	mergeHeads := make([]*git.AnnotatedCommit, 1)
 	mergeHeads[0] = annotatedCommit
 	analysis, _, err := repo.MergeAnalysis(mergeHeads)
 	if err != nil {
-		raven.CaptureError(err, nil)
-		Log.Errorf(" [%s] - Failed to perform merge analysis!", project)
+		reportError(err)
+		plog.Errorf("Failed to perform merge analysis!")
 		return err
 	}
 
 	if analysis&git.MergeAnalysisUpToDate != 0 {
-		Log.Debugf(" [%s] - Merge Analysis: Up-to-date!", project)
+		plog.Debugf("Merge Analysis: Up-to-date!")
 		return nil
 	} else if analysis&git.MergeAnalysisNormal != 0 {
-		Log.Debugf(" [%s] - Merge Analysis: Not Normal", project)
+		plog.Debugf("Merge Analysis: Not Normal")
 
 		// Just merge changes
 		if err := repo.Merge([]*git.AnnotatedCommit{annotatedCommit}, nil, nil); err != nil {
-			raven.CaptureError(err, nil)
+			reportError(err)
 			return err
 		}
 		// Check for conflicts
 		index, err := repo.Index()
 		if err != nil {
-			raven.CaptureError(err, nil)
-			Log.Errorf(" [%s] - Failed to determine the repository index!", project)
+			reportError(err)
+			plog.Errorf("Failed to determine the repository index!")
 			return err
 		}
-		Log.Debugf(" [%s] - Repository index acquired", project)
+		plog.Debugf("Repository index acquired")
 
 		if index.HasConflicts() {
-			Log.Errorf(" [%s] - Merge analysis found conflicts!", project)
+			plog.Errorf("Merge analysis found conflicts!")
 			return errors.New("conflicts encountered. Please resolve them")
 		}
-		Log.Debugf(" [%s] - Merge analysis did not find any conflicts.", project)
+		plog.Debugf("Merge analysis did not find any conflicts.")
 
 		// Make the merge commit
 		sig, err := repo.DefaultSignature()
 		if err != nil {
-			raven.CaptureError(err, nil)
-			Log.Errorf(" [%s] - Error performing merge commit using default signature", project)
+			reportError(err)
+			plog.Errorf("Error performing merge commit using default signature")
 			return err
 		}
-		Log.Debugf(" [%s] - Merge commit completed using default signature", project)
+		plog.Debugf("Merge commit completed using default signature")
 
 		// Get Write Tree
 		treeID, err := index.WriteTree()
 		if err != nil {
-			raven.CaptureError(err, nil)
-			Log.Errorf(" [%s] - Failed to get the write tree from the current index!", project)
+			reportError(err)
+			plog.Errorf("Failed to get the write tree from the current index!")
 			return err
 		}
-		Log.Debugf(" [%s] - Write Tree ID acquired from index", project)
+		plog.Debugf("Write Tree ID acquired from index")
 
 		tree, err := repo.LookupTree(treeID)
 		if err != nil {
-			raven.CaptureError(err, nil)
+			reportError(err)
 			return err
 		}
-		Log.Debugf(" [%s] - Tree lookup completed based on write tree ID", project)
+		plog.Debugf("Tree lookup completed based on write tree ID")
 
 		localCommit, err := repo.LookupCommit(head.Target())
 		if err != nil {
-			raven.CaptureError(err, nil)
-			Log.Errorf(" [%s] - Failed to lookup local commit from head target!", project)
+			reportError(err)
+			plog.Errorf("Failed to lookup local commit from head target!")
 			return err
 		}
-		Log.Debugf(" [%s] - Local commit for head target found", project)
+		plog.Debugf("Local commit for head target found")
 
 		remoteCommit, err := repo.LookupCommit(remoteBranchID)
 		if err != nil {
-			raven.CaptureError(err, nil)
-			Log.Errorf(" [%s] - Failed to lookup remote commit from remote branch ID '%s'!", project, remoteBranchID)
+			reportError(err)
+			plog.Errorf("Failed to lookup remote commit from remote branch ID '%s'!", remoteBranchID)
 			return err
 		}
-		Log.Debugf(" [%s] - Remote commit for remote branch ID '%s' found", project, remoteBranchID)
+		plog.Debugf("Remote commit for remote branch ID '%s' found", remoteBranchID)
 
 		repo.CreateCommit("HEAD", sig, sig, "", tree, localCommit, remoteCommit)
 
 		// Clean up
-		Log.Debugf(" [%s] - Performing state cleanup post-commit", project)
+		plog.Debugf("Performing state cleanup post-commit")
 		repo.StateCleanup()
 	} else if analysis&git.MergeAnalysisFastForward != 0 {
-		Log.Debugf(" [%s] - Merge Analysis: Fast-Forward", project)
+		plog.Debugf("Merge Analysis: Fast-Forward")
 
 		// Fast-forward changes
 		// Get remote tree
 		remoteTree, err := repo.LookupTree(remoteBranchID)
 		if err != nil {
-			raven.CaptureError(err, nil)
-			Log.Errorf(" [%s] - Failed to lookup remote tree for remote branch ID '%s' during fast-forward!", project, remoteBranchID)
+			reportError(err)
+			plog.Errorf("Failed to lookup remote tree for remote branch ID '%s' during fast-forward!", remoteBranchID)
 			return err
 		}
-		Log.Debugf(" [%s] - Found remote tree for remote branch ID '%s'", project, remoteBranchID)
+		plog.Debugf("Found remote tree for remote branch ID '%s'", remoteBranchID)
 
 		// Checkout
 		if coErr := repo.CheckoutTree(remoteTree, nil); coErr != nil {
-			raven.CaptureError(coErr, nil)
-			Log.Errorf(" [%s] - Failed to checkout remote tree during fast-forward!", project)
+			reportError(coErr)
+			plog.Errorf("Failed to checkout remote tree during fast-forward!")
 			return coErr
 		}
-		Log.Debugf(" [%s] - Checked out remote tree", project)
+		plog.Debugf("Checked out remote tree")
 
 		branchRef, err := repo.References.Lookup("refs/heads/" + branch)
 		if err != nil {
-			raven.CaptureError(err, nil)
-			Log.Errorf(" [%s] - Failed to lookup branch ref for '%s' as 'refs/heads/%s' during fast-forward!", project, branch, branch)
+			reportError(err)
+			plog.Errorf("Failed to lookup branch ref for '%s' as 'refs/heads/%s' during fast-forward!", branch, branch)
 			return err
 		}
-		Log.Debugf(" [%s] - Looked up branch ref for '%s' as 'refs/heads/%s'.", project, branch, branch)
+		plog.Debugf("Looked up branch ref for '%s' as 'refs/heads/%s'.", branch, branch)
 
 		// Point branch to the object
 		branchRef.SetTarget(remoteBranchID, "")
 		if _, err := head.SetTarget(remoteBranchID, ""); err != nil {
-			raven.CaptureError(err, nil)
-			Log.Errorf(" [%s] - Failed to set branch ref to target object ID; Fast forward failed!", project)
+			reportError(err)
+			plog.Errorf("Failed to set branch ref to target object ID; Fast forward failed!")
 			return err
 		}
-		Log.Debugf(" [%s] - Set branch ref to target object ID, Fast-forward complete.", project)
+		plog.Debugf("Set branch ref to target object ID, Fast-forward complete.")
 
 	} else {
-		Log.Errorf(" [%s] - Unexpected merge analysis result %d", project, analysis)
+		plog.Errorf("Unexpected merge analysis result %d", analysis)
 		return errors.New("unexpected merge analysis result")
 	}
 
@@ -310,7 +312,7 @@ func checkoutBranch(repo *git.Repository, branchName string) error {
 	//Getting the reference for the remote branch
 	remoteBranch, err := repo.LookupBranch("origin/"+branchName, git.BranchRemote)
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		Log.Error("Failed to find remote branch: " + branchName)
 		return err
 	}
@@ -319,7 +321,7 @@ func checkoutBranch(repo *git.Repository, branchName string) error {
 	// Lookup for commit from remote branch
 	commit, err := repo.LookupCommit(remoteBranch.Target())
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		Log.Error("Failed to find remote branch commit: " + branchName)
 		return err
 	}
@@ -331,7 +333,7 @@ func checkoutBranch(repo *git.Repository, branchName string) error {
 		// Creating local branch
 		localBranch, err = repo.CreateBranch(branchName, commit, false)
 		if err != nil {
-			raven.CaptureError(err, nil)
+			reportError(err)
 			Log.Error("Failed to create local branch: " + branchName)
 			return err
 		}
@@ -339,7 +341,7 @@ func checkoutBranch(repo *git.Repository, branchName string) error {
 		// Setting upstream to origin branch
 		err = localBranch.SetUpstream("origin/" + branchName)
 		if err != nil {
-			raven.CaptureError(err, nil)
+			reportError(err)
 			Log.Error("Failed to create upstream to origin/" + branchName)
 			return err
 		}
@@ -352,7 +354,7 @@ func checkoutBranch(repo *git.Repository, branchName string) error {
 	// Getting the tree for the branch
 	localCommit, err := repo.LookupCommit(localBranch.Target())
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		Log.Error("Failed to lookup for commit in local branch " + branchName)
 		return err
 	}
@@ -360,7 +362,7 @@ func checkoutBranch(repo *git.Repository, branchName string) error {
 
 	tree, err := repo.LookupTree(localCommit.TreeId())
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		Log.Error("Failed to lookup for tree " + branchName)
 		return err
 	}
@@ -369,7 +371,7 @@ func checkoutBranch(repo *git.Repository, branchName string) error {
 	// Checkout the tree
 	err = repo.CheckoutTree(tree, checkoutOpts)
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		Log.Error("Failed to checkout tree " + branchName)
 		return err
 	}
@@ -382,31 +384,47 @@ func checkoutBranch(repo *git.Repository, branchName string) error {
 func describeWorkDir(repo *git.Repository, project string) (string, error) {
 	describeOpts, err := git.DefaultDescribeOptions()
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		Log.Error("Failed to load git describe options for project " + project)
 		return "", err
 	}
 
 	formatOpts, err := git.DefaultDescribeFormatOptions()
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		Log.Error("Failed to load git describe format options for project " + project)
 		return "", err
 	}
 
 	result, err := repo.DescribeWorkdir(&describeOpts)
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		Log.Error("Failed to describe working directory for project " + project)
 		return "", err
 	}
 
 	resultStr, err := result.Format(&formatOpts)
 	if err != nil {
-		raven.CaptureError(err, nil)
+		reportError(err)
 		Log.Error("Failed to format working directory description for project " + project)
 		return "", err
 	}
 
 	return resultStr, nil
 }
+
+func headCommitID(repo *git.Repository, project string) (string, error) {
+	head, err := repo.Head()
+	if err != nil {
+		reportError(err)
+		Log.Error("Failed to find current HEAD for project " + project)
+		return "", err
+	}
+
+	if head == nil || head.Target() == nil {
+		Log.Error("Current HEAD has no target commit for project " + project)
+		return "", errors.New("failed to find current HEAD commit")
+	}
+
+	return head.Target().String(), nil
+}